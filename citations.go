@@ -0,0 +1,394 @@
+package grobidclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidBibTeX, if BibTeX input cannot be parsed.
+var ErrInvalidBibTeX = errors.New("invalid bibtex")
+
+// reference collects the fields of a bibliographic entry, that are needed to
+// format a citation string.
+type reference struct {
+	authors   []string
+	year      string
+	title     string
+	container string // journal or book title
+	volume    string
+	issue     string
+	pages     string
+	publisher string
+	doi       string
+}
+
+// String formats the reference in a common citation style, like "Smith, J.,
+// Doe, A. (2019). Title. Journal 23(2), 235-243. doi:10.1/2", which GROBID
+// can parse. Missing fields are left out.
+func (r *reference) String() string {
+	var parts []string
+	var head []string
+	if len(r.authors) > 0 {
+		head = append(head, strings.Join(r.authors, ", "))
+	}
+	if r.year != "" {
+		head = append(head, "("+r.year+")")
+	}
+	if len(head) > 0 {
+		parts = append(parts, strings.Join(head, " "))
+	}
+	if r.title != "" {
+		parts = append(parts, r.title)
+	}
+	var source string
+	if r.container != "" {
+		source = r.container
+	}
+	if r.volume != "" {
+		source = strings.TrimSpace(source + " " + r.volume)
+	}
+	if r.issue != "" {
+		source += "(" + r.issue + ")"
+	}
+	if r.pages != "" {
+		if source != "" {
+			source += ", "
+		}
+		source += r.pages
+	}
+	if source != "" {
+		parts = append(parts, source)
+	}
+	if r.publisher != "" {
+		parts = append(parts, r.publisher)
+	}
+	if r.doi != "" {
+		parts = append(parts, "doi:"+r.doi)
+	}
+	for i, p := range parts {
+		parts[i] = strings.TrimRight(p, ".")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, ". ") + "."
+}
+
+// CitationsFromBibTeX returns a citation string for each entry in BibTeX
+// input, suitable as input for the processCitationList service, one per
+// line. String definitions, comments and preambles are skipped, as are
+// entries without any usable field. Only common LaTeX markup is removed.
+func CitationsFromBibTeX(r io.Reader) ([]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		s      = string(b)
+		result []string
+	)
+	for {
+		i := strings.IndexByte(s, '@')
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+		j := strings.IndexAny(s, "{(")
+		if j < 0 {
+			return nil, fmt.Errorf("%w: missing entry body", ErrInvalidBibTeX)
+		}
+		typ := strings.ToLower(strings.TrimSpace(s[:j]))
+		body, rest, err := bibtexBody(s[j:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %q: %v", ErrInvalidBibTeX, typ, err)
+		}
+		s = rest
+		switch typ {
+		case "comment", "string", "preamble":
+			continue
+		}
+		fields, err := bibtexFields(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %q: %v", ErrInvalidBibTeX, typ, err)
+		}
+		ref := &reference{
+			year:      fields["year"],
+			title:     fields["title"],
+			container: firstNonEmpty(fields["journal"], fields["booktitle"]),
+			volume:    fields["volume"],
+			issue:     firstNonEmpty(fields["number"], fields["issue"]),
+			pages:     fields["pages"],
+			publisher: firstNonEmpty(fields["publisher"], fields["institution"], fields["school"]),
+			doi:       fields["doi"],
+		}
+		if v := fields["author"]; v != "" {
+			ref.authors = strings.Split(v, " and ")
+		}
+		if v := ref.String(); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// bibtexBody returns the body of an entry, starting at its opening brace or
+// parenthesis, and the remaining input.
+func bibtexBody(s string) (body, rest string, err error) {
+	closing := byte('}')
+	if s[0] == '(' {
+		closing = ')'
+	}
+	depth := 0
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case s[i] == closing && depth == 0:
+			return s[1:i], s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("unbalanced braces")
+}
+
+// bibtexFields parses the comma separated fields of an entry body, skipping
+// the citation key. Field names are lowercased, values are cleaned of LaTeX
+// markup.
+func bibtexFields(body string) (map[string]string, error) {
+	fields := make(map[string]string)
+	// Skip the citation key.
+	if i := strings.IndexByte(body, ','); i >= 0 {
+		body = body[i+1:]
+	} else {
+		return fields, nil
+	}
+	for {
+		body = strings.TrimLeftFunc(body, func(r rune) bool { return unicode.IsSpace(r) || r == ',' })
+		if body == "" {
+			return fields, nil
+		}
+		i := strings.IndexByte(body, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("missing value after %q", body)
+		}
+		name := strings.ToLower(strings.TrimSpace(body[:i]))
+		body = strings.TrimSpace(body[i+1:])
+		var value string
+		value, body = bibtexValue(body)
+		switch name {
+		case "doi", "url":
+			// Dashes and tildes are part of identifiers and links.
+			fields[name] = strings.TrimSpace(latexEscapeReplacer.Replace(value))
+		default:
+			fields[name] = cleanLaTeX(value)
+		}
+	}
+}
+
+// bibtexValue returns a field value, which may be braced, quoted or bare and
+// may be concatenated with "#", and the remaining input.
+func bibtexValue(s string) (value, rest string) {
+	var sb strings.Builder
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "{"):
+			depth := 0
+			i := 0
+			for ; i < len(s); i++ {
+				if s[i] == '{' {
+					depth++
+				} else if s[i] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			sb.WriteString(s[1:min(i, len(s))])
+			s = s[min(i+1, len(s)):]
+		case strings.HasPrefix(s, `"`):
+			depth := 0
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] == '{' {
+					depth++
+				} else if s[i] == '}' {
+					depth--
+				} else if s[i] == '"' && depth == 0 {
+					break
+				}
+			}
+			sb.WriteString(s[1:min(i, len(s))])
+			s = s[min(i+1, len(s)):]
+		default:
+			// A number or a string macro, which is kept verbatim.
+			i := strings.IndexAny(s, ",#")
+			if i < 0 {
+				i = len(s)
+			}
+			sb.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, "#") {
+			return sb.String(), s
+		}
+		s = s[1:]
+	}
+}
+
+// latexEscapeReplacer replaces escaped special characters.
+var latexEscapeReplacer = strings.NewReplacer(
+	`\&`, "&",
+	`\%`, "%",
+	`\_`, "_",
+)
+
+// latexReplacer replaces common LaTeX markup found in BibTeX values.
+var latexReplacer = strings.NewReplacer(
+	`\&`, "&",
+	`\%`, "%",
+	`\_`, "_",
+	`\ss`, "ß",
+	`---`, "—",
+	`--`, "-",
+	`~`, " ",
+	`{`, "",
+	`}`, "",
+)
+
+// latexAccent matches an accent command with its letter, which may be
+// braced, like \"o, {\"o}, \"{o}, \~{n}, \'{\i} or \c{c}. Accents written with
+// a letter, like \c, need braces or a space before the letter.
+var latexAccent = regexp.MustCompile(`\\(?:(["'` + "`" + `^~=.])\s*|([cvuH])(?:\s+|\s*\{))\{?\s*\\?([A-Za-z])\s*\}?`)
+
+// latexAccents maps accent commands to the letters they apply to and the
+// corresponding composed characters.
+var latexAccents = map[string][2]string{
+	`"`: {"aeiouyAEIOUY", "äëïöüÿÄËÏÖÜŸ"},
+	`'`: {"aeiouycnszAEIOUYCNSZ", "áéíóúýćńśźÁÉÍÓÚÝĆŃŚŹ"},
+	"`": {"aeiouAEIOU", "àèìòùÀÈÌÒÙ"},
+	`^`: {"aeiouAEIOU", "âêîôûÂÊÎÔÛ"},
+	`~`: {"anoANO", "ãñõÃÑÕ"},
+	`=`: {"aeiouAEIOU", "āēīōūĀĒĪŌŪ"},
+	`.`: {"zZI", "żŻİ"},
+	`c`: {"csCS", "çşÇŞ"},
+	`v`: {"cesznrCESZNR", "čěšžňřČĚŠŽŇŘ"},
+	`u`: {"agAG", "ăğĂĞ"},
+	`H`: {"ouOU", "őűŐŰ"},
+}
+
+// replaceAccent returns the composed character for an accent command, or
+// the bare letter, if the combination is unknown.
+func replaceAccent(m string) string {
+	sm := latexAccent.FindStringSubmatch(m)
+	accent, letter := sm[1]+sm[2], sm[3]
+	if v, ok := latexAccents[accent]; ok {
+		if i := strings.Index(v[0], letter); i >= 0 {
+			return string([]rune(v[1])[i])
+		}
+	}
+	return letter
+}
+
+// cleanLaTeX replaces accent commands, like {\"o}, with composed characters,
+// removes braces and other simple markup and collapses whitespace.
+func cleanLaTeX(s string) string {
+	s = latexAccent.ReplaceAllStringFunc(s, replaceAccent)
+	s = latexReplacer.Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// CitationsFromRIS returns a citation string for each record in RIS input,
+// suitable as input for the processCitationList service, one per line.
+// Records start with a TY tag and end with an ER tag; unknown tags are
+// ignored.
+func CitationsFromRIS(r io.Reader) ([]string, error) {
+	var (
+		br     = bufio.NewScanner(r)
+		result []string
+		ref    *reference
+		sp, ep string
+	)
+	flush := func() {
+		if ref == nil {
+			return
+		}
+		switch {
+		case sp != "" && ep != "" && sp != ep:
+			ref.pages = sp + "-" + ep
+		case sp != "":
+			ref.pages = sp
+		}
+		if v := ref.String(); v != "" {
+			result = append(result, v)
+		}
+		ref, sp, ep = nil, "", ""
+	}
+	for br.Scan() {
+		line := strings.TrimRight(br.Text(), " \r")
+		// Tags are two characters, followed by two spaces and a dash.
+		if len(line) < 5 || line[2:5] != "  -" {
+			continue
+		}
+		tag, value := line[:2], strings.TrimSpace(line[5:])
+		switch tag {
+		case "TY":
+			flush()
+			ref = &reference{}
+			continue
+		case "ER":
+			flush()
+			continue
+		}
+		if ref == nil || value == "" {
+			continue
+		}
+		switch tag {
+		case "AU", "A1":
+			ref.authors = append(ref.authors, value)
+		case "TI", "T1":
+			ref.title = firstNonEmpty(ref.title, value)
+		case "JO", "JF", "T2", "JA", "BT":
+			ref.container = firstNonEmpty(ref.container, value)
+		case "PY", "Y1", "DA":
+			if ref.year == "" && len(value) >= 4 {
+				ref.year = value[:4]
+			}
+		case "VL":
+			ref.volume = value
+		case "IS":
+			ref.issue = value
+		case "SP":
+			sp = value
+		case "EP":
+			ep = value
+		case "PB":
+			ref.publisher = value
+		case "DO":
+			ref.doi = value
+		}
+	}
+	if err := br.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return result, nil
+}
+
+// firstNonEmpty returns the first non-empty string or the empty string.
+func firstNonEmpty(vs ...string) string {
+	for _, v := range vs {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package grobidclient
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCitationsFromBibTeX(t *testing.T) {
+	var cases = []struct {
+		about  string
+		data   string
+		result []string
+		err    error
+	}{
+		{
+			about:  "empty",
+			data:   "",
+			result: nil,
+		},
+		{
+			about: "article",
+			data: `@Article{cunningham2019,
+  author  = {Cunningham, H. B. and Weis, J. J.},
+  title   = {Mesh migration following abdominal hernia repair: a comprehensive review},
+  journal = "Hernia",
+  year    = 2019,
+  volume  = {23},
+  number  = {2},
+  pages   = {235--243},
+  doi     = {10.1007/s10029-019-01898-9},
+}`,
+			result: []string{
+				"Cunningham, H. B., Weis, J. J. (2019). Mesh migration following abdominal hernia repair: a comprehensive review. Hernia 23(2), 235-243. doi:10.1007/s10029-019-01898-9.",
+			},
+		},
+		{
+			about: "markup, comments and strings",
+			data: `@comment{ignore me}
+@string{tods = "ACM Transactions on Database Systems"}
+@inproceedings(g{\"o}del,
+  author = {Kurt G{\"o}del},
+  title = {{On Formally Undecidable Propositions} \& {Related Systems}},
+  booktitle = "Proc. of " # "Logic",
+  year = {1931}
+)
+@book{empty}`,
+			result: []string{
+				"Kurt Gödel (1931). On Formally Undecidable Propositions & Related Systems. Proc. of Logic.",
+			},
+		},
+		{
+			about: "accents, doi and url",
+			data: `@article{x,
+  author = {Fran\c{c}ois Mu\~{n}oz and J{\'e}r{\^o}me M\"uller and Dvo\v{r}\'{a}k, A.},
+  title = {Na\"{\i}ve Stra{\ss}e},
+  year = {2001},
+  doi = {10.1002/(SICI)1097-4571(199806)49:8--700::AID-ASI3~2},
+  url = {https://example.com/a--b~c},
+}`,
+			result: []string{
+				"François Muñoz, Jérôme Müller, Dvořák, A. (2001). Naïve Straße. doi:10.1002/(SICI)1097-4571(199806)49:8--700::AID-ASI3~2.",
+			},
+		},
+		{
+			about: "unbalanced",
+			data:  `@article{x, title = {Open`,
+			err:   ErrInvalidBibTeX,
+		},
+	}
+	for _, c := range cases {
+		result, err := CitationsFromBibTeX(strings.NewReader(c.data))
+		if !errors.Is(err, c.err) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if !reflect.DeepEqual(result, c.result) {
+			t.Fatalf("[%s] got %q, want %q", c.about, result, c.result)
+		}
+	}
+}
+
+func TestCitationsFromRIS(t *testing.T) {
+	var data = `TY  - JOUR
+AU  - Cunningham, H. B.
+AU  - Weis, J. J.
+TI  - Mesh migration following abdominal hernia repair
+T2  - Hernia
+PY  - 2019/01/30
+VL  - 23
+IS  - 2
+SP  - 235
+EP  - 243
+DO  - 10.1007/s10029-019-01898-9
+ER  -
+
+TY  - BOOK
+AU  - Bass, M.
+TI  - Handbook of Optics
+PB  - McGraw-Hill
+PY  - 2010
+ER  -
+TY  - GEN
+ER  -
+`
+	result, err := CitationsFromRIS(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := []string{
+		"Cunningham, H. B., Weis, J. J. (2019). Mesh migration following abdominal hernia repair. Hernia 23(2), 235-243. doi:10.1007/s10029-019-01898-9.",
+		"Bass, M. (2010). Handbook of Optics. McGraw-Hill.",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("got %q, want %q", result, want)
+	}
+}
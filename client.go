@@ -1,28 +1,44 @@
 package grobidclient
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"container/list"
 	"context"
 	"crypto/sha1"
+	"database/sql"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/beevik/etree"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/miku/grobidclient/tei"
 	"github.com/sethgrid/pester"
 )
 
@@ -32,6 +48,46 @@ var Version = "0.2.3"
 // ErrInvalidService, if the service name is not known.
 var ErrInvalidService = errors.New("invalid service")
 
+// ErrUnexpectedContentType, if the server responded successfully, but not
+// with XML, e.g. with an HTML page from a misconfigured proxy, JSON or plain
+// text.
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// ErrDownloadFailed, if a PDF could not be downloaded from a URL.
+var ErrDownloadFailed = errors.New("download failed")
+
+// ErrUnsupportedInput, if a file cannot be processed with a given service.
+var ErrUnsupportedInput = errors.New("unsupported input for service")
+
+// ErrNameCollision, if NameFunc maps two results to the same output file.
+var ErrNameCollision = errors.New("output name collision")
+
+// ErrPDFTooLarge, if the server rejected a PDF for exceeding its size limit,
+// either the upload size or the number of blocks or tokens.
+var ErrPDFTooLarge = errors.New("pdf too large")
+
+// ErrInvalidTableName, if a table name is not a plain SQL identifier.
+var ErrInvalidTableName = errors.New("invalid table name")
+
+// ErrEmptyBody, if the server kept responding with HTTP 200 and an empty
+// body, see Options.RetryOnEmptyBody.
+var ErrEmptyBody = errors.New("empty response body")
+
+// DeadlineError is returned by batch processing, if Options.Deadline passed
+// before all inputs were started. Inputs in flight are finished first.
+type DeadlineError struct {
+	Completed int64 // inputs passed to the ResultFunc
+	// Remaining counts the inputs, that were found but not started. A
+	// directory walk stops at the deadline, so inputs it has not reached
+	// yet are not included.
+	Remaining int64
+}
+
+// Error reports the number of completed and remaining inputs.
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("deadline passed: %d completed, %d remaining", e.Completed, e.Remaining)
+}
+
 // DefaultExt for structured metadata outputs.
 const DefaultExt = "grobid.tei.xml"
 
@@ -44,6 +100,9 @@ var ValidServices = []string{
 	"processCitationList",
 	"processCitationPatentST36",
 	"processCitationPatentPDF",
+	"processFulltextAssetDocument",
+	"processDate",
+	"processHeaderNames",
 }
 
 // IsValidService returns true, if the service name is valid.
@@ -56,6 +115,16 @@ func IsValidService(name string) bool {
 	return false
 }
 
+// DefaultTEICoordinates is the recommended list of elements to request
+// coordinates for.
+var DefaultTEICoordinates = []string{"ref", "figure", "persName", "formula", "biblStruct"}
+
+// KnownTEICoordinates are the elements GROBID can report coordinates for.
+var KnownTEICoordinates = []string{
+	"ref", "figure", "persName", "formula", "biblStruct",
+	"head", "s", "p", "note", "title", "affiliation",
+}
+
 // DefaultOptions to send to GROBID.
 var DefaultOptions = &Options{
 	GenerateIDs:            true,
@@ -63,7 +132,7 @@ var DefaultOptions = &Options{
 	ConsolidateCitations:   true,
 	IncludeRawCitations:    true,
 	IncluseRawAffiliations: true,
-	TEICoordinates:         []string{"ref", "figure", "persName", "formula", "biblStruct"},
+	TEICoordinates:         slices.Clone(DefaultTEICoordinates),
 	SegmentSentences:       true,
 	Force:                  false,
 	Verbose:                false,
@@ -75,41 +144,313 @@ var DefaultOptions = &Options{
 // https://grobid.readthedocs.io/en/latest/Grobid-service/#grobid-web-services.
 type Options struct {
 	GenerateIDs            bool
-	ConsolidateHeader      bool
-	ConsolidateCitations   bool
+	ConsolidateHeader      bool // Deprecated: use ConsolidateHeaderLevel
+	ConsolidateCitations   bool // Deprecated: use ConsolidateCitationsLevel
 	IncludeRawCitations    bool
 	IncluseRawAffiliations bool
 	TEICoordinates         []string // https://grobid.readthedocs.io/en/latest/Coordinates-in-PDF/
+	TEICoordinatesAll      bool     // request DefaultTEICoordinates, unless TEICoordinates is set
 	SegmentSentences       bool
 	Force                  bool
 	Verbose                bool
 	OutputDir              string
 	CreateHashSymlinks     bool
+	StreamToDisk           bool // write PDF responses to the output file directly, see Result.Streamed
+	ShardDepth             int  // place outputs in OutputDir and hash symlinks under ab/cd/... subdirectories, by SHA1 of the input
+	SkipOversize           bool // do not treat ErrPDFTooLarge as an error in batch mode
+	// PreserveTree, with OutputDir set, mirrors the directory structure of
+	// the inputs below Root in OutputDir, so files with the same name in
+	// different directories do not collide. Inputs outside of Root are
+	// written to OutputDir directly.
+	PreserveTree bool
+	// Root is the directory input paths are made relative to with
+	// PreserveTree. If empty, the directory scanned by ProcessDirRecursive,
+	// ProcessDirResults or ReprocessErrors is used, and the archive root
+	// for ProcessZip and ProcessTar.
+	Root string
+	// Timeout and TimeoutPerMB limit the time for processing a single PDF
+	// file, including retries. The limit is Timeout plus TimeoutPerMB for
+	// each megabyte of input, so large files get more time, but never less
+	// than the timeout of the HTTP client, if known. The timeout of the HTTP
+	// client still ends each attempt, so for large files to get more time,
+	// it must be zero, as grobidcli does with -timeout-per-mb; the client
+	// returned by New has a timeout of 60 seconds.
+	Timeout      time.Duration
+	TimeoutPerMB time.Duration
+	// WalkBufferSize is the number of paths a directory walk may queue up
+	// ahead of the workers. If zero, the walk only continues, when a worker
+	// is ready for the next file.
+	WalkBufferSize int
+	// FileFilter, if set, is consulted for each file and directory during a
+	// directory walk, before the built-in service and filetype rules. If it
+	// returns false, the file or the whole directory is skipped.
+	FileFilter func(path string, info fs.FileInfo) bool
+	// Include and Exclude are glob patterns, as understood by
+	// filepath.Match, applied to the base name of each file during a
+	// directory walk, after FileFilter. If Include is set, only matching
+	// files are considered. A file matching Exclude is always skipped.
+	Include []string
+	Exclude []string
+	// CitationBatchSize, if greater than zero, splits citation lists into
+	// batches of this size, which are processed concurrently, using up to
+	// CitationBatchWorkers requests at a time (defaults to the number of
+	// CPUs).
+	CitationBatchSize    int
+	CitationBatchWorkers int
+	// TraceFunc, if set, is called with each outgoing PDF request and the
+	// form fields sent along with the file, just before the request is
+	// sent. Useful for debugging rejected requests.
+	TraceFunc func(req *http.Request, fields url.Values)
+	// Deadline, if not zero, stops batch processing from starting on new
+	// inputs once it has passed. Inputs in flight are finished, a directory
+	// walk is stopped and a *DeadlineError reports the number of completed
+	// and remaining inputs.
+	Deadline time.Time
+	// FailFast stops batch processing on the first error returned by the
+	// ResultFunc. Inputs in flight are cancelled, no new inputs are started
+	// and the directory walk stops. The first error is returned.
+	FailFast bool
+	// ConsolidateHeaderLevel and ConsolidateCitationsLevel set the GROBID
+	// consolidation level: 0 is off, 1 consolidates all fields, 2 only adds
+	// the DOI. If a level is 0, the corresponding boolean option is used
+	// as level 1.
+	ConsolidateHeaderLevel    int
+	ConsolidateCitationsLevel int
+	// InputFieldName and AcceptHeader override the multipart field name of
+	// the uploaded file, "input", and the accepted response type, usually
+	// "application/xml", e.g. to target a service wrapping GROBID. Changing
+	// these may produce responses, that cannot be parsed as TEI. Citation
+	// lists requested as "application/json" can be parsed with
+	// Result.Citations.
+	InputFieldName string
+	AcceptHeader   string
+	// StateFile, if set, is an append-only log of successfully processed
+	// inputs. It is loaded on start, and inputs found in the log are skipped
+	// without checking for existing output files.
+	StateFile string
+	// NameFunc, if set, is used by DefaultResultWriter to name the output
+	// file of a successful result, e.g. after a DOI, instead of deriving the
+	// name from the input file. A relative name is placed into OutputDir, or
+	// next to the input file, if OutputDir is empty. Since the name is only
+	// known after processing, already processed files are not skipped and
+	// StreamToDisk has no effect.
+	NameFunc func(r *Result) (string, error)
+	// ErrorOnNameCollision makes a writer returned by NewDefaultResultWriter
+	// fail with ErrNameCollision, if NameFunc returns the same name twice.
+	// DefaultResultWriter keeps no record of names and fails with this set.
+	ErrorOnNameCollision bool
+	// Transform, if set, is called by batch workers with each successful
+	// result, before it is passed to the ResultFunc, e.g. to redact or
+	// convert the response body in place. If it fails, the result carries
+	// the error instead. Streamed results have no body to transform.
+	Transform func(r *Result) error
+	// WriteJSON makes DefaultResultWriter write the parsed document as JSON
+	// next to each TEI file, as name.grobid.json. If the TEI cannot be
+	// parsed, the error message is written to name.grobid.json.err instead.
+	WriteJSON bool
+	// CompressRequest gzip compresses citation list requests larger than
+	// compressThreshold. If the server rejects the encoding with HTTP 415,
+	// the request is repeated uncompressed.
+	CompressRequest bool
+	// VerifyProcessed makes the check for an existing output file, which
+	// lets batch processing skip an input, also verify that the file is a
+	// complete TEI document. Outputs left incomplete by an interrupted run
+	// are then processed again. Inputs found in StateFile are still skipped.
+	VerifyProcessed bool
+	// UploadProgress, if set, is called by ProcessPDFContext as the file is
+	// sent, with the number of bytes read from the file so far and its
+	// size, to tell slow uploads from slow processing. It is called from the
+	// goroutine performing the upload.
+	UploadProgress func(sent, total int64)
+	// RetryOnEmptyBody makes ProcessPDFContext and batch processing repeat a
+	// request, that succeeded with an empty body, which GROBID occasionally
+	// returns under load, up to emptyBodyAttempts times in total. If all
+	// attempts come back empty, the result carries ErrEmptyBody. In batches,
+	// only inputs, whose reader implements io.Seeker, like files and archive
+	// entries, are retried.
+	RetryOnEmptyBody bool
+	// Logger, if set, is used by the result writers. Batch processing sets
+	// it to the logger of the client, if empty. Otherwise, slog.Default is
+	// used.
+	Logger *slog.Logger
 }
 
-// writeFields writes flags to a multipart writer.
-func (opts *Options) writeFields(w *multipart.Writer) {
-	if opts.ConsolidateCitations {
-		w.WriteField("consolidateCitations", "1")
+// logger returns the configured logger or the default logger.
+func (opts *Options) logger() *slog.Logger {
+	if opts != nil && opts.Logger != nil {
+		return opts.Logger
+	}
+	return slog.Default()
+}
+
+// nameRegistry records output names, to detect collisions.
+type nameRegistry struct {
+	mu   sync.Mutex
+	seen map[string]string // output name to input filename
+}
+
+// add records name for filename and returns the filename, that already
+// maps to name, if any.
+func (r *nameRegistry) add(name, filename string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.seen[name]; ok {
+		return prev, true
+	}
+	if r.seen == nil {
+		r.seen = make(map[string]string)
+	}
+	r.seen[name] = filename
+	return "", false
+}
+
+// FormFields returns the form fields sent to GROBID along with the input file,
+// excluding the input file itself. The fields are also suitable for GROBID
+// compatible services. Fields may be repeated, like teiCoordinates.
+func (opts *Options) FormFields() url.Values {
+	v := url.Values{}
+	if level := opts.consolidateCitationsLevel(); level > 0 {
+		v.Set("consolidateCitations", strconv.Itoa(level))
 	}
-	if opts.ConsolidateHeader {
-		w.WriteField("consolidateHeader", "1")
+	if level := opts.consolidateHeaderLevel(); level > 0 {
+		v.Set("consolidateHeader", strconv.Itoa(level))
 	}
 	if opts.GenerateIDs {
-		w.WriteField("generateIDs", "1")
+		v.Set("generateIDs", "1")
 	}
 	if opts.IncludeRawCitations {
-		w.WriteField("includeRawCitations", "1")
+		v.Set("includeRawCitations", "1")
 	}
 	if opts.IncluseRawAffiliations {
-		w.WriteField("includeRawAffiliations", "1")
+		v.Set("includeRawAffiliations", "1")
 	}
 	if opts.SegmentSentences {
-		w.WriteField("segmentSentences", "1")
+		v.Set("segmentSentences", "1")
+	}
+	for _, c := range opts.teiCoordinates() {
+		v.Add("teiCoordinates", c)
+	}
+	return v
+}
+
+// teiCoordinates returns the elements to request coordinates for, without
+// duplicates and in the order given.
+func (opts *Options) teiCoordinates() []string {
+	coords := opts.TEICoordinates
+	if len(coords) == 0 && opts.TEICoordinatesAll {
+		coords = DefaultTEICoordinates
+	}
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+	for _, c := range coords {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		result = append(result, c)
+	}
+	return result
+}
+
+// warnUnknownTEICoordinates logs a warning for each requested coordinate
+// element, that GROBID does not know about. These are passed on regardless.
+func (g *Grobid) warnUnknownTEICoordinates(opts *Options) {
+	for _, c := range opts.teiCoordinates() {
+		if !slices.Contains(KnownTEICoordinates, c) {
+			g.logger().Warn("unknown tei coordinates element", "name", c)
+		}
+	}
+}
+
+// consolidateHeaderLevel returns the header consolidation level, falling back
+// to the boolean option.
+func (opts *Options) consolidateHeaderLevel() int {
+	if opts.ConsolidateHeaderLevel == 0 && opts.ConsolidateHeader {
+		return 1
+	}
+	return opts.ConsolidateHeaderLevel
+}
+
+// consolidateCitationsLevel returns the citation consolidation level, falling
+// back to the boolean option.
+func (opts *Options) consolidateCitationsLevel() int {
+	if opts.ConsolidateCitationsLevel == 0 && opts.ConsolidateCitations {
+		return 1
+	}
+	return opts.ConsolidateCitationsLevel
+}
+
+// writeFields writes flags to a multipart writer, in a stable order.
+func (opts *Options) writeFields(w *multipart.Writer) {
+	v := opts.FormFields()
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, value := range v[k] {
+			w.WriteField(k, value)
+		}
+	}
+}
+
+// inputFieldName returns the multipart field name for the uploaded file.
+func (opts *Options) inputFieldName() string {
+	if opts.InputFieldName != "" {
+		return opts.InputFieldName
+	}
+	return "input"
+}
+
+// acceptHeader returns the accept header to send for a given service.
+func (opts *Options) acceptHeader(service string) string {
+	if opts.AcceptHeader != "" {
+		return opts.AcceptHeader
+	}
+	return acceptHeader(service)
+}
+
+// filterFile applies the FileFilter, if set, and the Include and Exclude
+// patterns and returns true, if the path should be skipped. For skipped
+// directories, filepath.SkipDir is returned as well, so it can be passed on
+// to the walk function. An invalid pattern is returned as error.
+func (opts *Options) filterFile(path string, info fs.FileInfo) (bool, error) {
+	if opts.FileFilter != nil && !opts.FileFilter(path, info) {
+		if info.IsDir() {
+			return true, filepath.SkipDir
+		}
+		return true, nil
 	}
-	for _, v := range opts.TEICoordinates {
-		w.WriteField("teiCoordinates", v)
+	if info.IsDir() {
+		return false, nil
+	}
+	name := filepath.Base(path)
+	excluded, err := matchAny(opts.Exclude, name)
+	if err != nil || excluded {
+		return true, err
+	}
+	if len(opts.Include) == 0 {
+		return false, nil
+	}
+	included, err := matchAny(opts.Include, name)
+	return !included, err
+}
+
+// matchAny returns true, if name matches any of the glob patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := filepath.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("%w: %q", err, p)
+		}
+		if ok {
+			return true, nil
+		}
 	}
+	return false, nil
 }
 
 // Result wraps a server response, not necessarily successful. If processing
@@ -121,6 +462,37 @@ type Result struct {
 	Body           []byte
 	Err            error
 	ProcessingTime time.Duration
+	ServerHeaders  http.Header // timing related response headers, if any
+	ContentType    string      // content type of the response, as sent by the server
+	RetryCount     int         // number of retried attempts, requires a client with NewCountingTransport
+	OutputPath     string      // set by result writers, after writing the result
+	Streamed       bool        // body has been written to OutputPath directly, Body is empty
+}
+
+// serverTimingHeaders are response headers carrying server side timing or
+// diagnostic information.
+var serverTimingHeaders = []string{
+	"X-Response-Time",
+	"X-Runtime",
+	"X-Processing-Time",
+	"Server-Timing",
+}
+
+// serverHeaders returns the timing related headers found in h or nil, if
+// there are none.
+func serverHeaders(h http.Header) http.Header {
+	var result http.Header
+	for _, k := range serverTimingHeaders {
+		vs := h.Values(k)
+		if len(vs) == 0 {
+			continue
+		}
+		if result == nil {
+			result = make(http.Header)
+		}
+		result[k] = vs
+	}
+	return result
 }
 
 // StringBody returns the response body as string.
@@ -128,6 +500,49 @@ func (r *Result) StringBody() string {
 	return string(r.Body)
 }
 
+// IsSuccess returns true, if the server responded with 200 OK and a
+// non-empty body, and no error was detected in the response.
+func (r *Result) IsSuccess() bool {
+	return r.StatusCode == http.StatusOK && (len(r.Body) > 0 || r.Streamed) && r.Err == nil
+}
+
+// IsRetryable returns true, if the request failed in a way that may succeed
+// on a later attempt, i.e. the server was busy or unavailable or there was a
+// network error.
+func (r *Result) IsRetryable() bool {
+	switch r.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	if errors.Is(r.Err, ErrEmptyBody) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(r.Err, &ne)
+}
+
+// Citations parses the citations in a successful citation list response. The
+// format, TEI or JSON, is detected from the response content type.
+func (r *Result) Citations() ([]*tei.GrobidBiblio, error) {
+	if isJSON(r.ContentType) {
+		return tei.ParseCitationsJSON(r.Body)
+	}
+	return tei.ParseCitationList(string(r.Body)), nil
+}
+
+// isJSON returns true, if the content type denotes a JSON document.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
 // String representation of a result.
 func (r *Result) String() string {
 	return fmt.Sprintf("%d on %s, body: %s", r.StatusCode, r.Filename, string(r.Body))
@@ -138,10 +553,69 @@ type Doer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// attemptCounterKey is the context key for the attempt counter of a request.
+type attemptCounterKey struct{}
+
+// countingTransport counts round trips of requests, which carry a counter in
+// their context.
+type countingTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip increments the attempt counter of the request, if any, and
+// passes the request on.
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c, ok := req.Context().Value(attemptCounterKey{}).(*atomic.Int64); ok {
+		c.Add(1)
+	}
+	if t.next == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// NewCountingTransport wraps a transport, so the attempts made for a single
+// PDF are counted and reported as Result.RetryCount. It needs to be installed
+// in the HTTP client used by a retrying Doer, as a Doer only sees a single
+// call per request. If next is nil, http.DefaultTransport is used.
+func NewCountingTransport(next http.RoundTripper) http.RoundTripper {
+	return &countingTransport{next: next}
+}
+
+// testDoer is a Doer, which passes requests to a function instead of sending
+// them to a server.
+type testDoer func(*http.Request) (*http.Response, error)
+
+// Do calls the function and consumes the rest of the request body, as a HTTP
+// client would, so request bodies streamed from a pipe do not block.
+func (f testDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := f(req)
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	if resp != nil && resp.Body == nil {
+		resp.Body = http.NoBody
+	}
+	return resp, err
+}
+
+// NewTestClient returns a Grobid client, which does not talk to a server, but
+// passes each request to a function, which can inspect the request and return
+// a canned response. This allows to test ResultFuncs and options without a
+// running GROBID instance.
+func NewTestClient(f func(*http.Request) (*http.Response, error)) *Grobid {
+	return &Grobid{
+		Server: "http://grobid.test",
+		Client: testDoer(f),
+	}
+}
+
 // New creates a new Grobid client with a recommended, resilient HTTP client.
 func New(server string) *Grobid {
 	hc := &http.Client{
-		Timeout: 60 * time.Second,
+		Timeout:   60 * time.Second,
+		Transport: NewCountingTransport(nil),
 	}
 	client := pester.NewExtendedClient(hc)
 	client.MaxRetries = 3
@@ -157,6 +631,131 @@ func New(server string) *Grobid {
 type Grobid struct {
 	Server string
 	Client Doer
+	Logger *slog.Logger // if nil, slog.Default is used
+	Cache  Cache        // responses by SHA1 of the input, service and options, if nil, NopCache is used
+}
+
+// cache returns the configured cache or a cache that does not store anything.
+func (g *Grobid) cache() Cache {
+	if g.Cache != nil {
+		return g.Cache
+	}
+	return NopCache{}
+}
+
+// clientTimeout returns the timeout of the HTTP client, or zero, if there is
+// none or it cannot be determined. For a pester client, the timeout of the
+// wrapped HTTP client is used.
+func (g *Grobid) clientTimeout() time.Duration {
+	switch c := g.Client.(type) {
+	case *http.Client:
+		return c.Timeout
+	case *pester.Client:
+		// The wrapped client is not exported, but its timeout can be read.
+		hc := reflect.ValueOf(c).Elem().FieldByName("hc")
+		if !hc.IsValid() || hc.IsNil() {
+			return c.Timeout
+		}
+		return time.Duration(hc.Elem().FieldByName("Timeout").Int())
+	}
+	return 0
+}
+
+// Cache stores successful responses, so identical PDFs are not sent to the
+// server again. Keys are made from the SHA1 of the input, the service, the
+// accept header and the form fields, so a cache can be shared between
+// services and options. It is used for PDF files, both single and in
+// batches, and for archive entries. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, b []byte)
+}
+
+// cacheKey returns the cache key for a response to an input with the given
+// SHA1, processed with service and options.
+func cacheKey(sha1hex, service string, opts *Options) string {
+	return sha1hex + " " + service + " " + opts.acceptHeader(service) + " " + opts.FormFields().Encode()
+}
+
+// NopCache does not store anything.
+type NopCache struct{}
+
+// Get always reports a miss.
+func (NopCache) Get(string) ([]byte, bool) { return nil, false }
+
+// Put does nothing.
+func (NopCache) Put(string, []byte) {}
+
+// LRUCache is an in-memory cache holding a limited number of responses,
+// evicting the least recently used one first.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+// lruEntry is a key value pair kept in the LRU list.
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// NewLRUCache returns a cache holding at most size responses. A size smaller
+// than one is treated as one.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:    max(1, size),
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns a cached response and marks it as recently used. The returned
+// slice must not be modified.
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// Put adds or updates a response, possibly evicting the least recently used
+// one.
+func (c *LRUCache) Put(key string, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = b
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry{key: key, value: b})
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// logger returns the configured logger or the default logger.
+func (g *Grobid) logger() *slog.Logger {
+	if g.Logger != nil {
+		return g.Logger
+	}
+	return slog.Default()
 }
 
 // Ping tests the server connection.
@@ -179,6 +778,38 @@ func (g *Grobid) Ping() error {
 	return nil
 }
 
+// maxWaitInterval limits the time between two attempts of WaitReady.
+const maxWaitInterval = 30 * time.Second
+
+// WaitReady blocks until the server reports to be alive or ctx is done. The
+// first attempts are interval apart, after each failed attempt the interval
+// is doubled, up to 30s. If ctx is done first, the context error is returned,
+// along with the error of the last attempt.
+func (g *Grobid) WaitReady(ctx context.Context, interval time.Duration) error {
+	if _, err := url.JoinPath(g.Server, "api"); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	wait := interval
+	for {
+		_, err := g.getAPI(ctx, "isalive")
+		if err == nil {
+			return nil
+		}
+		g.logger().Info("waiting for server", "server", g.Server, "err", err, "retry", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-timer.C:
+		}
+		wait = min(2*wait, max(interval, maxWaitInterval))
+	}
+}
+
 // Pingmoji returns an emoji rendering of a ping result.
 func (g *Grobid) Pingmoji() string {
 	if err := g.Ping(); err == nil {
@@ -188,191 +819,1666 @@ func (g *Grobid) Pingmoji() string {
 	}
 }
 
-// withoutExt returns the given file or path without the extension.
+// HealthStatus summarizes server availability, as reported by Health.
+type HealthStatus struct {
+	Alive   bool
+	Latency time.Duration // roundtrip time of the isalive request
+	Version string
+	Err     error
+}
+
+// Health checks whether the server is alive and queries its version. Any
+// error talking to the server is reported in the Err field of the status; an
+// error is only returned, if the server URL is invalid.
+func (g *Grobid) Health(ctx context.Context) (*HealthStatus, error) {
+	if _, err := url.JoinPath(g.Server, "api"); err != nil {
+		return nil, err
+	}
+	var (
+		hs      = &HealthStatus{}
+		started = time.Now()
+	)
+	_, err := g.getAPI(ctx, "isalive")
+	hs.Latency = time.Since(started)
+	if err != nil {
+		hs.Err = err
+		return hs, nil
+	}
+	hs.Alive = true
+	b, err := g.getAPI(ctx, "version")
+	if err != nil {
+		hs.Err = err
+		return hs, nil
+	}
+	hs.Version = parseVersion(b)
+	return hs, nil
+}
+
+// getAPI issues a GET request to a given API endpoint and returns the
+// response body, if the server responded with HTTP 200.
+func (g *Grobid) getAPI(ctx context.Context, name string) ([]byte, error) {
+	u, err := url.JoinPath(g.Server, "api", name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with: %v", http.StatusText(resp.StatusCode))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseVersion extracts the version from a version API response, which is
+// either plain text in older GROBID versions or a JSON object.
+func parseVersion(b []byte) string {
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &v); err == nil && v.Version != "" {
+		return v.Version
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// RecommendedWorkers returns the number of client workers for a server with
+// a given concurrency. The client should send slightly more requests than
+// the server can handle in parallel, about 1.5 times the server threads, cf.
+// https://github.com/kermitt2/grobid/issues/443#issuecomment-505208132.
+// GROBID does not report its concurrency, which is the "concurrency" setting
+// in grobid.yaml, over the API; /api/version only returns the version and
+// revision. So it has to be known in advance.
+func RecommendedWorkers(concurrency int) int {
+	return max(1, concurrency*3/2)
+}
+
+// withoutExt returns the given file or path without the extension. A gzip
+// suffix is removed as well, so "a.pdf.gz" becomes "a".
 func withoutExt(filepath string) string {
+	filepath = strings.TrimSuffix(filepath, ".gz")
 	return strings.TrimSuffix(filepath, path.Ext(filepath))
 }
 
 // outputFilename returns a suitable output filename. If dir is empty, the
-// output is written in the same directory as the input file.
+// output is written in the same directory as the input file. With ShardDepth,
+// files placed into OutputDir directly go into subdirectories named after
+// the SHA1 of the input, see outputFilenameSHA1.
 func outputFilename(filepath string, opts *Options) string {
+	return outputFilenameSHA1(filepath, "", opts)
+}
+
+// outputFilenameSHA1 returns the output filename for an input with a given
+// SHA1, as in Result.SHA1Hex. With ShardDepth, the output is placed into
+// subdirectories named after the SHA1, like ab/cd/name.grobid.tei.xml,
+// next to the hash symlinks. If the SHA1 is empty, a local input file is
+// hashed. Only if the contents are not available, like for a URL, that
+// could not be downloaded, the SHA1 of the output name is used.
+func outputFilenameSHA1(filepath, sha1hex string, opts *Options) string {
+	if u, ok := inputURL(filepath); ok {
+		return flatOutputFilename(urlOutputName(u)+"."+DefaultExt, sha1hex, opts)
+	}
 	if opts.OutputDir == "" {
 		return withoutExt(filepath) + "." + DefaultExt
-	} else {
-		return path.Join(opts.OutputDir, withoutExt(path.Base(filepath))+"."+DefaultExt)
+	} else if rel, ok := opts.relativeToRoot(filepath); ok {
+		return path.Join(opts.OutputDir, withoutExt(rel)+"."+DefaultExt)
 	}
+	if sha1hex == "" && opts.ShardDepth > 0 {
+		sha1hex, _ = fileSHA1(filepath)
+	}
+	return flatOutputFilename(withoutExt(path.Base(filepath))+"."+DefaultExt, sha1hex, opts)
 }
 
-// isAlreadyProcessed returns true, if the file at a given path has been
-// processed. Note: this does not work with hash based naming as for those the
-// file contents needs to be completely read already. This should be a fast
-// operation.
-func (g *Grobid) isAlreadyProcessed(path string, opts *Options) bool {
-	name := outputFilename(path, opts)
-	_, err := os.Stat(name)
-	return err == nil
+// flatOutputFilename returns the path of an output file called name in
+// OutputDir, or in the current directory, if OutputDir is empty. With
+// ShardDepth, the file is placed into subdirectories named after sha1hex, or
+// after the SHA1 of name, if sha1hex is empty.
+func flatOutputFilename(name, sha1hex string, opts *Options) string {
+	if opts.ShardDepth <= 0 || opts.OutputDir == "" {
+		return path.Join(opts.OutputDir, name)
+	}
+	if sha1hex == "" {
+		sha1hex = fmt.Sprintf("%x", sha1.Sum([]byte(name)))
+	}
+	parts := append([]string{opts.OutputDir}, shardDirs(sha1hex, opts.ShardDepth)...)
+	return path.Join(append(parts, name)...)
 }
 
-// ResultFunc is a function invoked on the result of the processing.
-type ResultFunc func(*Result, *Options) error
+// fileSHA1 returns the SHA1 of the contents of a local file, as computed for
+// Result.SHA1Hex.
+func fileSHA1(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerSHA1(f)
+}
 
-// DebugResultWriter is a dummy result writer, which only logs the result.
-func DebugResultWriter(result *Result, _ *Options) error {
-	if result.Err != nil {
-		log.Printf("[%d][%s] %s [%v][%v]",
-			result.StatusCode, result.SHA1Hex, result.Filename, result.ProcessingTime, result.Err)
-	} else {
-		log.Printf("[%d][%s] %s [%v][]",
-			result.StatusCode, result.SHA1Hex, result.Filename, result.ProcessingTime)
+// inputURL returns the parsed URL and true, if the name of an input is an
+// HTTP URL, as recorded by ProcessPDFURL.
+func inputURL(name string) (*url.URL, bool) {
+	if !strings.HasPrefix(name, "http://") && !strings.HasPrefix(name, "https://") {
+		return nil, false
 	}
-	return result.Err
+	u, err := url.Parse(name)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	return u, true
 }
 
-// DefaultResultWriter is a ResultFunc that writes out a single file with the
-// result. It contains handling to write out error results akin to the Python
-// grobid client library.
-func DefaultResultWriter(result *Result, opts *Options) error {
-	if opts == nil {
-		opts = DefaultOptions
+// unsafeNameChars matches runs of characters, which are not kept in file
+// names derived from URLs.
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// maxURLNameLen limits the readable part of a file name derived from a URL.
+const maxURLNameLen = 100
+
+// urlOutputName returns a file name without extension for the output of a
+// URL, like "arxiv.org_pdf_2101.00001_1a2b3c4d". It is made of the host and
+// path, with other characters replaced, and a short hash of the whole URL,
+// so URLs differing only in their query or in replaced characters do not
+// collide.
+func urlOutputName(u *url.URL) string {
+	p := strings.TrimSuffix(u.EscapedPath(), ".gz")
+	if strings.HasSuffix(strings.ToLower(p), ".pdf") {
+		p = p[:len(p)-len(".pdf")]
 	}
-	if result == nil || reflect.DeepEqual(result, &Result{}) {
-		return nil
+	name := strings.Trim(unsafeNameChars.ReplaceAllString(u.Host+"/"+p, "_"), "_.")
+	if len(name) > maxURLNameLen {
+		name = name[:maxURLNameLen]
 	}
-	dst := outputFilename(result.Filename, opts)
-	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
-		return err
+	h := sha1.Sum([]byte(u.String()))
+	return fmt.Sprintf("%s_%x", name, h[:4])
+}
+
+// isSharded returns true, if outputFilename places the output for filepath
+// into ShardDepth levels of subdirectories of OutputDir.
+func (opts *Options) isSharded(filepath string) bool {
+	if opts.ShardDepth <= 0 || opts.OutputDir == "" {
+		return false
 	}
-	if result.StatusCode != 200 || len(result.Body) == 0 {
-		// writing error file with suffixed error code
-		dst = strings.Replace(dst, "."+DefaultExt, fmt.Sprintf("_%d.txt", result.StatusCode), 1)
-		return os.WriteFile(dst, result.Body, 0644)
+	_, ok := opts.relativeToRoot(filepath)
+	return !ok
+}
+
+// shardDirs returns up to depth directory names, the two character prefixes
+// of a hex encoded hash.
+func shardDirs(hexhash string, depth int) []string {
+	var dirs []string
+	for i := 0; i < depth && 2*i+2 <= len(hexhash); i++ {
+		dirs = append(dirs, hexhash[2*i:2*i+2])
 	}
-	if opts.Verbose {
-		log.Printf("done: %s", dst)
+	return dirs
+}
+
+// relativeToRoot returns the path of name relative to Root and true, if
+// PreserveTree is set and name is below Root.
+func (opts *Options) relativeToRoot(name string) (string, bool) {
+	if !opts.PreserveTree || opts.Root == "" {
+		return "", false
+	}
+	if _, ok := inputURL(name); ok {
+		return "", false
+	}
+	rel, err := filepath.Rel(opts.Root, name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// requestTimeout returns the time limit for processing an input of a given
+// size in bytes, or zero, if there is no limit. The limit is at least the
+// timeout of the HTTP client, so a single attempt is never cut short.
+func (opts *Options) requestTimeout(size int64, clientTimeout time.Duration) time.Duration {
+	if opts.Timeout <= 0 && opts.TimeoutPerMB <= 0 {
+		return 0
+	}
+	return max(opts.Timeout+time.Duration(float64(opts.TimeoutPerMB)*float64(size)/(1<<20)), clientTimeout)
+}
+
+// withFileTimeout returns a context, which is limited by the request timeout
+// for the file at path. The size is only looked up, if a timeout is set.
+func (opts *Options) withFileTimeout(ctx context.Context, path string, clientTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if opts.Timeout <= 0 && opts.TimeoutPerMB <= 0 {
+		return ctx, func() {}
+	}
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	return context.WithTimeout(ctx, opts.requestTimeout(size, clientTimeout))
+}
+
+// withRoot returns options with the scanned directory as Root for
+// PreserveTree, unless a Root has been set already. The options passed in
+// are left unchanged, so they can be reused for another directory.
+func (opts *Options) withRoot(dir string) *Options {
+	if !opts.PreserveTree || opts.Root != "" {
+		return opts
 	}
-	// write TEI file
-	err := os.WriteFile(dst, result.Body, 0644)
+	o := *opts
+	o.Root = dir
+	return &o
+}
+
+// namedOutputFilename returns the output filename for a result, as determined
+// by NameFunc. With ErrorOnNameCollision, names are checked against the names
+// registered so far.
+func namedOutputFilename(result *Result, opts *Options, names *nameRegistry) (string, error) {
+	name, err := opts.NameFunc(result)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("%s: %w", result.Filename, err)
 	}
-	if opts.CreateHashSymlinks {
-		link := path.Join(path.Dir(dst), fmt.Sprintf("%s.%s", result.SHA1Hex, DefaultExt))
-		if err := os.Symlink(path.Base(dst), link); err != nil {
-			return err
+	if name == "" {
+		return "", fmt.Errorf("%s: empty output name", result.Filename)
+	}
+	switch {
+	case path.IsAbs(name):
+	case opts.OutputDir != "":
+		name = path.Join(opts.OutputDir, name)
+	default:
+		name = path.Join(path.Dir(result.Filename), name)
+	}
+	if opts.ErrorOnNameCollision {
+		if names == nil {
+			return "", errors.New("name collisions can only be detected by a writer from NewDefaultResultWriter")
+		}
+		if prev, ok := names.add(name, result.Filename); ok {
+			return "", fmt.Errorf("%w: %s and %s both map to %s",
+				ErrNameCollision, prev, result.Filename, name)
 		}
 	}
-	return nil
+	return name, nil
 }
 
-// ProcessDirRecursive recursively walks a given directory "dir" and run
-// parsing using "service" on each file. A number of workers can be started and
-// a ResultFunc can be specified, which gets called for each result, e.g. to
-// write debug output to stderr or to write a file with the structured metadata
-// to disk. Options contain options to be passed to GROBID API, using defaults
-// if they are not set.
-func (g *Grobid) ProcessDirRecursive(dir, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+// hashOutputFilename returns the path of the hash named output file in dir.
+// If ShardDepth is set, the file is placed into that many levels of
+// subdirectories, named after two character prefixes of the hash.
+func hashOutputFilename(dir, sha1hex string, opts *Options) string {
+	parts := append([]string{dir}, shardDirs(sha1hex, opts.ShardDepth)...)
+	return path.Join(append(parts, sha1hex+"."+DefaultExt)...)
+}
+
+// isAlreadyProcessed returns true, if the file at a given path has been
+// processed. Note: this does not work with hash based naming as for those the
+// file contents needs to be completely read already. This should be a fast
+// operation; with ShardDepth, the file is hashed to find its shard, which is
+// still much cheaper than processing it.
+func (g *Grobid) isAlreadyProcessed(path string, opts *Options) bool {
+	if opts.NameFunc != nil {
+		return false
+	}
+	name := outputFilename(path, opts)
+	if !opts.VerifyProcessed {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+	if err := checkTEIFile(name); err != nil {
+		if !os.IsNotExist(err) {
+			g.logger().Info("invalid output, reprocessing", "name", path, "output", name, "err", err)
+		}
+		return false
+	}
+	return true
+}
+
+// checkTEIFile returns an error, if the file at name is not a complete XML
+// document with a TEI root element, e.g. because an earlier run was
+// interrupted while writing it. The document is only tokenized, not parsed.
+func checkTEIFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 	var (
-		pathC        = make(chan string)
-		errC         = make(chan error)
-		done         = make(chan bool)
-		wg           sync.WaitGroup
-		errList      []error
-		numProcessed int
+		dec   = xml.NewDecoder(bufio.NewReader(f))
+		depth int
 	)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 && t.Name.Local != "TEI" {
+				return fmt.Errorf("unexpected root element: %s", t.Name.Local)
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	return errors.New("incomplete document")
+}
+
+// ResultFunc is a function invoked on the result of the processing.
+type ResultFunc func(*Result, *Options) error
+
+// DebugResultWriter is a dummy result writer, which only logs the result.
+func DebugResultWriter(result *Result, opts *Options) error {
+	attrs := []any{"status", result.StatusCode, "sha1", result.SHA1Hex,
+		"name", result.Filename, "t", result.ProcessingTime}
+	if result.Err != nil {
+		attrs = append(attrs, "err", result.Err)
+	}
+	opts.logger().Info("result", attrs...)
+	return result.Err
+}
+
+// DefaultResultWriter is a ResultFunc that writes out a single file with the
+// result. It contains handling to write out error results akin to the Python
+// grobid client library. The path of the written file is recorded in
+// OutputPath, so a ResultFunc wrapping this writer can pick it up.
+func DefaultResultWriter(result *Result, opts *Options) error {
+	return writeResult(result, opts, nil)
+}
+
+// NewDefaultResultWriter returns a ResultFunc like DefaultResultWriter, which
+// also records the names returned by NameFunc, to detect collisions with
+// ErrorOnNameCollision. The record is kept for the lifetime of the writer, so
+// use a new writer for each run.
+func NewDefaultResultWriter() ResultFunc {
+	names := &nameRegistry{}
+	return func(result *Result, opts *Options) error {
+		return writeResult(result, opts, names)
+	}
+}
+
+// writeResult implements DefaultResultWriter, names may be nil.
+func writeResult(result *Result, opts *Options, names *nameRegistry) error {
 	if opts == nil {
 		opts = DefaultOptions
 	}
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for path := range pathC {
-				if g.isAlreadyProcessed(path, opts) && !opts.Force {
-					log.Printf("already processed: %s", path)
-					continue
+	if result == nil || reflect.DeepEqual(result, &Result{}) {
+		return nil
+	}
+	dst := outputFilenameSHA1(result.Filename, result.SHA1Hex, opts)
+	if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if !result.IsSuccess() {
+		// writing error file with suffixed error code
+		dst = strings.Replace(dst, "."+DefaultExt, fmt.Sprintf("_%d.txt", result.StatusCode), 1)
+		if err := os.WriteFile(dst, result.Body, 0644); err != nil {
+			return err
+		}
+		result.OutputPath = dst
+		return nil
+	}
+	switch {
+	case result.Streamed:
+		dst = result.OutputPath
+	case opts.NameFunc != nil:
+		name, err := namedOutputFilename(result, opts, names)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, result.Body, 0644); err != nil {
+			return err
+		}
+		dst = name
+		result.OutputPath = dst
+	default:
+		// write TEI file
+		if err := os.WriteFile(dst, result.Body, 0644); err != nil {
+			return err
+		}
+		result.OutputPath = dst
+	}
+	if opts.Verbose {
+		opts.logger().Info("done", "name", dst)
+	}
+	if opts.WriteJSON {
+		if err := writeJSONSidecar(result, dst); err != nil {
+			return err
+		}
+	}
+	if opts.CreateHashSymlinks {
+		dir := path.Dir(dst)
+		if opts.NameFunc == nil && opts.isSharded(result.Filename) {
+			// Keep the symlinks next to the shards, not inside them.
+			dir = opts.OutputDir
+		}
+		link := hashOutputFilename(dir, result.SHA1Hex, opts)
+		if err := os.MkdirAll(path.Dir(link), 0755); err != nil {
+			return err
+		}
+		target, err := filepath.Rel(path.Dir(link), dst)
+		if err != nil {
+			return err
+		}
+		if err := ensureSymlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonOutputFilename returns the name of the JSON file written next to the
+// TEI file dst.
+func jsonOutputFilename(dst string) string {
+	if strings.HasSuffix(dst, "."+DefaultExt) {
+		return strings.TrimSuffix(dst, "."+DefaultExt) + ".grobid.json"
+	}
+	return dst + ".json"
+}
+
+// writeJSONSidecar parses the TEI of a successful result, written to dst, and
+// writes it as indented JSON next to it. A parse error is not returned, but
+// written to a file with an additional ".err" extension.
+func writeJSONSidecar(result *Result, dst string) error {
+	body := result.Body
+	if result.Streamed {
+		var err error
+		if body, err = os.ReadFile(dst); err != nil {
+			return err
+		}
+	}
+	var (
+		name     = jsonOutputFilename(dst)
+		errName  = name + ".err"
+		doc, err = tei.ParseDocument(bytes.NewReader(body))
+	)
+	if err != nil {
+		return os.WriteFile(errName, []byte(err.Error()+"\n"), 0644)
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	// Remove an error file left over from an earlier run.
+	if err := os.Remove(errName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ensureSymlink creates a symlink at link pointing to target. An existing
+// symlink pointing to the same target is kept, a symlink pointing elsewhere
+// is replaced. Any other existing file at link is an error.
+func ensureSymlink(target, link string) error {
+	err := os.Symlink(target, link)
+	if err == nil || !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	fi, err := os.Lstat(link)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return fmt.Errorf("cannot create symlink, file exists: %s", link)
+	}
+	current, err := os.Readlink(link)
+	if err != nil {
+		return err
+	}
+	if current == target {
+		return nil
+	}
+	if err := os.Remove(link); err != nil {
+		return err
+	}
+	return os.Symlink(target, link)
+}
+
+// NewTEICorpusResultWriter returns a ResultFunc, which writes the TEI
+// documents of all successful results into a single teiCorpus document to w,
+// and a function to finish the document, which must be called after
+// processing. Unsuccessful results are skipped. The ResultFunc is safe for
+// concurrent use.
+func NewTEICorpusResultWriter(w io.Writer) (ResultFunc, func() error) {
+	var (
+		mu      sync.Mutex
+		started bool
+	)
+	// start writes the opening tag, if necessary; must be called with the
+	// lock held.
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		_, err := io.WriteString(w, xml.Header+`<teiCorpus xmlns="http://www.tei-c.org/ns/1.0">`+"\n")
+		return err
+	}
+	rf := func(result *Result, _ *Options) error {
+		if result == nil || !result.IsSuccess() {
+			return nil
+		}
+		body := result.Body
+		if result.Streamed {
+			var err error
+			if body, err = os.ReadFile(result.OutputPath); err != nil {
+				return err
+			}
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(body); err != nil {
+			return fmt.Errorf("%s: %w", result.Filename, err)
+		}
+		if doc.Root() == nil || doc.Root().Tag != "TEI" {
+			return fmt.Errorf("%s: no TEI root element", result.Filename)
+		}
+		// Keep only the root element, dropping the XML declaration.
+		root := doc.Root()
+		for _, t := range append([]etree.Token(nil), doc.Child...) {
+			if t != root {
+				doc.RemoveChild(t)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := start(); err != nil {
+			return err
+		}
+		if _, err := doc.WriteTo(w); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	finish := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := start(); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "</teiCorpus>\n")
+		return err
+	}
+	return rf, finish
+}
+
+// sqlIdentifier matches table names, which are safe to use unquoted.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewJSONLinesResultWriter returns a ResultFunc, which writes one JSON
+// object per result and line to w, e.g. for piping into other tools. Each
+// line contains the filename, SHA1 and status code of the result and either
+// the parsed document or an error message, if processing or parsing failed.
+// The ResultFunc is safe for concurrent use.
+func NewJSONLinesResultWriter(w io.Writer) ResultFunc {
+	var (
+		mu  sync.Mutex
+		enc = json.NewEncoder(w)
+	)
+	enc.SetEscapeHTML(false)
+	return func(result *Result, _ *Options) error {
+		if result == nil {
+			return nil
+		}
+		line := struct {
+			Filename   string              `json:"filename"`
+			SHA1Hex    string              `json:"sha1,omitempty"`
+			StatusCode int                 `json:"status"`
+			Document   *tei.GrobidDocument `json:"document,omitempty"`
+			Err        string              `json:"err,omitempty"`
+		}{
+			Filename:   result.Filename,
+			SHA1Hex:    result.SHA1Hex,
+			StatusCode: result.StatusCode,
+		}
+		switch {
+		case result.IsSuccess():
+			body := result.Body
+			if result.Streamed {
+				var err error
+				if body, err = os.ReadFile(result.OutputPath); err != nil {
+					return err
 				}
-				var (
-					result *Result
-					err    error
-				)
-				switch {
-				case service == "processCitationList":
-					result, err = g.ProcessText(path, service, opts)
-				default:
-					result, err = g.ProcessPDF(path, service, opts)
+			}
+			doc, err := tei.ParseDocument(bytes.NewReader(body))
+			if err != nil {
+				line.Err = err.Error()
+			} else {
+				line.Document = doc
+			}
+		case result.Err != nil:
+			line.Err = result.Err.Error()
+		default:
+			line.Err = http.StatusText(result.StatusCode)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(line)
+	}
+}
+
+// NewSQLiteResultWriter returns a ResultFunc, which stores one row per result
+// in a SQLite table, which is created if it does not exist. The row is keyed
+// by filename and is replaced, if the file is processed again. For successful
+// results, the TEI is parsed to fill the version, title and DOI columns and
+// the document is stored as JSON. If the TEI cannot be parsed, these columns
+// are left empty. The database driver is left to the caller. The ResultFunc
+// is safe for concurrent use.
+func NewSQLiteResultWriter(db *sql.DB, table string) (ResultFunc, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTableName, table)
+	}
+	for _, q := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			filename TEXT PRIMARY KEY,
+			sha1 TEXT,
+			status INTEGER,
+			grobid_version TEXT,
+			title TEXT,
+			doi TEXT,
+			json TEXT
+		)`, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_sha1 ON %s (sha1)`, table, table),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_doi ON %s (doi)`, table, table),
+	} {
+		if _, err := db.Exec(q); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		mu    sync.Mutex
+		query = fmt.Sprintf(`INSERT OR REPLACE INTO %s
+			(filename, sha1, status, grobid_version, title, doi, json)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, table)
+	)
+	rf := func(result *Result, _ *Options) error {
+		if result == nil {
+			return nil
+		}
+		var version, title, doi, js sql.NullString
+		if result.IsSuccess() {
+			body := result.Body
+			if result.Streamed {
+				var err error
+				if body, err = os.ReadFile(result.OutputPath); err != nil {
+					return err
 				}
-				if result == nil {
-					result = &Result{
-						// If processing failed, return a pseudo-result
-						// nonetheless, so we still know know about the error
-						// conditions.
-						Filename:   path,
-						StatusCode: -1,
-						Err:        fmt.Errorf("process failed: %w", err),
-					}
+			}
+			if doc, err := tei.ParseDocument(bytes.NewReader(body)); err == nil {
+				b, err := json.Marshal(doc)
+				if err != nil {
+					return err
 				}
-				errC <- rf(result, opts)
+				version = sql.NullString{String: doc.GrobidVersion, Valid: true}
+				if doc.Header != nil {
+					title = sql.NullString{String: doc.Header.Title, Valid: true}
+					doi = sql.NullString{String: doc.Header.DOI, Valid: doc.Header.DOI != ""}
+				}
+				js = sql.NullString{String: string(b), Valid: true}
 			}
-		}()
+		}
+		// SQLite allows only a single writer at a time.
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := db.Exec(query, result.Filename, result.SHA1Hex, result.StatusCode,
+			version, title, doi, js)
+		return err
+	}
+	return rf, nil
+}
+
+// ProcessDirRecursive recursively walks a given directory "dir" and run
+// parsing using "service" on each file. A number of workers can be started and
+// a ResultFunc can be specified, which gets called for each result, e.g. to
+// write debug output to stderr or to write a file with the structured metadata
+// to disk. Options contain options to be passed to GROBID API, using defaults
+// if they are not set.
+func (g *Grobid) ProcessDirRecursive(dir, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	opts = opts.withRoot(dir)
+	return g.processPaths(context.Background(), service, numWorkers, rf, opts, g.walkDir(dir, service, opts))
+}
+
+// ProcessDirResults processes a directory like ProcessDirRecursive, but
+// passes the results on the returned channel, which is closed, when all files
+// have been processed or the context is cancelled. Processing errors are
+// reported in Result.Err; an error of the directory walk itself is sent as a
+// last result, named after the directory. An error is returned, if the
+// directory cannot be accessed.
+func (g *Grobid) ProcessDirResults(ctx context.Context, dir, service string, numWorkers int, opts *Options) (<-chan *Result, error) {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	opts = opts.withRoot(dir)
+	results := make(chan *Result)
+	send := func(result *Result, _ *Options) error {
+		select {
+		case results <- result:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 	go func() {
-		for err := range errC {
-			if err == nil {
+		defer close(results)
+		err := g.processPaths(ctx, service, numWorkers, send, opts, g.walkDir(dir, service, opts))
+		if err != nil && ctx.Err() == nil {
+			send(&Result{Filename: dir, StatusCode: -1, Err: err}, opts)
+		}
+	}()
+	return results, nil
+}
+
+// walkDir returns a walk function for processPaths, which enqueues all files
+// under dir suitable for the service.
+func (g *Grobid) walkDir(dir, service string, opts *Options) func(enqueue func(string) error) error {
+	return func(enqueue func(string) error) error {
+		skipped := make(map[string]bool)
+		return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ok, err := g.acceptFile(path, info, service, opts, skipped); !ok {
+				return err
+			}
+			if opts.Verbose {
+				g.logger().Info("enqueued", "path", path)
+			}
+			return enqueue(path)
+		})
+	}
+}
+
+// acceptFile returns true, if the file at path passes the filters in opts and
+// can be processed with service. Directories are never accepted; the error
+// is the one returned by filterFile. Files, that require another service,
+// are logged once per extension, as recorded in skipped, or each with
+// Verbose.
+func (g *Grobid) acceptFile(path string, info fs.FileInfo, service string, opts *Options, skipped map[string]bool) (bool, error) {
+	if skip, err := opts.filterFile(path, info); skip {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+	v, ok := ServiceForFile(path)
+	return g.acceptService(path, service, v, ok, opts, skipped), nil
+}
+
+// acceptService returns true, if the service v, detected for the file at
+// path, if ok, is service. Otherwise the file is logged as skipped, like in
+// acceptFile.
+func (g *Grobid) acceptService(path, service, v string, ok bool, opts *Options, skipped map[string]bool) bool {
+	switch {
+	case ok && v == service:
+		return true
+	case !ok:
+		if opts.Verbose {
+			g.logger().Info("skipping", "path", path)
+		}
+	case opts.Verbose:
+		g.logger().Info("skipping, file type requires another service",
+			"path", path, "service", v)
+	default:
+		if ext := strings.ToLower(filepath.Ext(path)); !skipped[ext] {
+			skipped[ext] = true
+			g.logger().Info("skipping files, file type requires another service, further files are skipped silently",
+				"ext", ext, "path", path, "service", v)
+		}
+	}
+	return false
+}
+
+// ProcessZip processes the files in a ZIP archive like ProcessDirRecursive
+// processes a directory. Entry names, which are relative paths, serve as
+// filenames, so outputs are written relative to the current directory,
+// unless opts.OutputDir is set. With PreserveTree, the archive structure is
+// mirrored in OutputDir. Entries with names pointing outside of the archive,
+// like "../a.pdf", are skipped.
+func (g *Grobid) ProcessZip(ctx context.Context, zipPath, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	var i int
+	return g.processArchive(ctx, service, numWorkers, rf, opts, func() (*archiveEntry, error) {
+		if i == len(zr.File) {
+			return nil, io.EOF
+		}
+		f := zr.File[i]
+		i++
+		return &archiveEntry{name: f.Name, info: f.FileInfo(), open: f.Open}, nil
+	})
+}
+
+// ProcessTar processes the files in a tar archive, which may be gzip
+// compressed, like ProcessZip.
+func (g *Grobid) ProcessTar(ctx context.Context, tarPath, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := maybeGunzip(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	return g.processArchive(ctx, service, numWorkers, rf, opts, func() (*archiveEntry, error) {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		return &archiveEntry{
+			name: hdr.Name,
+			info: hdr.FileInfo(),
+			open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}, nil
+	})
+}
+
+// archiveEntry is a file in an archive, which is only opened, if it is
+// going to be processed.
+type archiveEntry struct {
+	name string
+	info fs.FileInfo
+	open func() (io.ReadCloser, error)
+}
+
+// processArchive processes the regular files returned by next, until next
+// returns io.EOF. Files are filtered like in a directory walk, but PDFs are
+// detected from the content of each entry, not from its name. Since archives
+// are read sequentially, each file is read into memory, before it is passed
+// on to a worker.
+func (g *Grobid) processArchive(ctx context.Context, service string, numWorkers int, rf ResultFunc, opts *Options, next func() (*archiveEntry, error)) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	opts = opts.withRoot(".")
+	return g.processNamedReaders(ctx, service, numWorkers, rf, opts, func(enqueue func(NamedReader) error) error {
+		skipped := make(map[string]bool)
+		for {
+			entry, err := next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !entry.info.Mode().IsRegular() {
 				continue
 			}
-			// aggregate errors in error list
-			errList = append(errList, err)
+			name := path.Clean(entry.name)
+			if !filepath.IsLocal(name) {
+				g.logger().Warn("skipping archive entry outside of archive", "name", entry.name)
+				continue
+			}
+			if skip, err := opts.filterFile(name, entry.info); skip {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			rc, err := entry.open()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			// The type is detected from the entry itself, not from a
+			// local file, that happens to have the same name.
+			br := bufio.NewReaderSize(rc, sniffLen)
+			head, err := br.Peek(sniffLen)
+			if err != nil && err != io.EOF {
+				rc.Close()
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			v, ok := serviceForType(name, isPDFContent(head))
+			if !g.acceptService(name, service, v, ok, opts, skipped) {
+				rc.Close()
+				continue
+			}
+			b, err := io.ReadAll(br)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if opts.Verbose {
+				g.logger().Info("enqueued", "path", name)
+			}
+			if err := enqueue(NamedReader{Name: name, Reader: bytes.NewReader(b)}); err != nil {
+				return err
+			}
 		}
-		done <- true
-	}()
-	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+	})
+}
+
+// ReprocessErrors walks the output tree for error stubs left behind by
+// DefaultResultWriter (files named like "name_503.txt"), maps each of them
+// back to its source file under dir and reprocesses only those files. The
+// output tree is opts.OutputDir or dir, if no output directory is set. On
+// successful reprocessing, the stale error stub is removed.
+func (g *Grobid) ReprocessErrors(ctx context.Context, dir, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	opts = opts.withRoot(dir)
+	root := opts.OutputDir
+	if root == "" {
+		root = dir
+	}
+	// stubs maps an output filename prefix to all error stubs found for it.
+	stubs := make(map[string][]string)
+	err := filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if info.IsDir() {
 			return nil
 		}
-		// Note: Following the Python client, which has hardcoded rules for
-		// what service and what filetype fit together.
-		switch {
-		case service == "processFulltextDocument" && isPDF(path):
-			if opts.Verbose {
-				log.Printf("enqueued: %s", path)
+		if prefix, ok := errorStubPrefix(path); ok {
+			stubs[prefix] = append(stubs[prefix], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(stubs) == 0 {
+		g.logger().Info("no error stubs found", "dir", root)
+		return nil
+	}
+	sources := make(map[string][]string) // source file to error stubs
+	removeStubs := func(result *Result, opts *Options) error {
+		if err := rf(result, opts); err != nil {
+			return err
+		}
+		if !result.IsSuccess() {
+			return nil
+		}
+		for _, stub := range sources[result.Filename] {
+			if err := os.Remove(stub); err != nil && !os.IsNotExist(err) {
+				return err
 			}
-			pathC <- path
-		case service == "processCitationList" && isText(path):
-			if opts.Verbose {
-				log.Printf("enqueued: %s", path)
+		}
+		return nil
+	}
+	return g.processPaths(ctx, service, numWorkers, removeStubs, opts, func(enqueue func(string) error) error {
+		// Collect all sources first, so the stub lookup in the result func
+		// does not race with the walk.
+		err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
 			}
-			pathC <- path
-		case service == "processCitationPatentST36" && isXML(path):
-			if opts.Verbose {
-				log.Printf("enqueued: %s", path)
+			if skip, err := opts.filterFile(path, info); skip {
+				return err
 			}
-			pathC <- path
-		default:
-			if opts.Verbose {
-				log.Printf("skipping: %s", path)
+			if info.IsDir() {
+				return nil
 			}
+			if _, ok := errorStubPrefix(path); ok {
+				return nil
+			}
+			prefix := strings.TrimSuffix(outputFilename(path, opts), "."+DefaultExt)
+			v, ok := stubs[prefix]
+			if !ok || !matchesService(service, path) {
+				return nil
+			}
+			sources[path] = v
 			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for path := range sources {
+			if opts.Verbose {
+				g.logger().Info("enqueued", "path", path)
+			}
+			if err := enqueue(path); err != nil {
+				return err
+			}
 		}
-		numProcessed++
 		return nil
 	})
+}
+
+// errorStubPrefix returns the output filename prefix of an error stub, like
+// "dir/name" for "dir/name_503.txt" and true, or false, if the path does not
+// look like an error stub.
+func errorStubPrefix(path string) (string, bool) {
+	if !strings.HasSuffix(path, ".txt") {
+		return "", false
+	}
+	v := strings.TrimSuffix(path, ".txt")
+	i := strings.LastIndex(v, "_")
+	if i == -1 {
+		return "", false
+	}
+	// Status code is either an HTTP status or -1 for a pseudo-result.
+	code, err := strconv.Atoi(v[i+1:])
+	if err != nil || (code != -1 && (code < 100 || code > 599)) {
+		return "", false
+	}
+	return v[:i], true
+}
+
+// NamedReader is an input to process, e.g. a file or an object from a storage
+// service. If the reader implements io.Closer, it is closed after processing.
+type NamedReader struct {
+	Name string
+	io.Reader
+	// Service and Options, if set, override the service and the request
+	// options of the batch for this input.
+	Service string
+	Options *JobOptions
+}
+
+// Job is a single input file with its own service and request options, as
+// read by ProcessJobs, e.g. {"path": "a.pdf", "service":
+// "processHeaderDocument", "options": {"consolidateHeader": 1}}.
+type Job struct {
+	Path    string      `json:"path"`
+	Service string      `json:"service,omitempty"`
+	Options *JobOptions `json:"options,omitempty"`
+}
+
+// JobOptions are the request options, that can be set per job. Fields are
+// named after the GROBID API parameters. Unset fields are taken from the
+// batch options.
+type JobOptions struct {
+	ConsolidateHeader      *int     `json:"consolidateHeader,omitempty"`
+	ConsolidateCitations   *int     `json:"consolidateCitations,omitempty"`
+	GenerateIDs            *bool    `json:"generateIDs,omitempty"`
+	IncludeRawCitations    *bool    `json:"includeRawCitations,omitempty"`
+	IncludeRawAffiliations *bool    `json:"includeRawAffiliations,omitempty"`
+	SegmentSentences       *bool    `json:"segmentSentences,omitempty"`
+	TEICoordinates         []string `json:"teiCoordinates,omitempty"`
+}
+
+// apply returns the options of the batch, overridden by the job options.
+// All other options, like timeouts or StreamToDisk, are kept.
+func (o *JobOptions) apply(base *Options) *Options {
+	v := *base
+	opts := &v
+	// Resolve the deprecated and derived options first, so the job options
+	// take precedence over them.
+	opts.ConsolidateHeaderLevel = base.consolidateHeaderLevel()
+	opts.ConsolidateCitationsLevel = base.consolidateCitationsLevel()
+	opts.TEICoordinates = base.teiCoordinates()
+	for _, v := range []struct {
+		dst *int
+		src *int
+	}{
+		{&opts.ConsolidateHeaderLevel, o.ConsolidateHeader},
+		{&opts.ConsolidateCitationsLevel, o.ConsolidateCitations},
+	} {
+		if v.src != nil {
+			*v.dst = *v.src
+		}
+	}
+	for _, v := range []struct {
+		dst *bool
+		src *bool
+	}{
+		{&opts.GenerateIDs, o.GenerateIDs},
+		{&opts.IncludeRawCitations, o.IncludeRawCitations},
+		{&opts.IncluseRawAffiliations, o.IncludeRawAffiliations},
+		{&opts.SegmentSentences, o.SegmentSentences},
+	} {
+		if v.src != nil {
+			*v.dst = *v.src
+		}
+	}
+	if o.TEICoordinates != nil {
+		opts.TEICoordinates = o.TEICoordinates
+	}
+	return opts
+}
+
+// ProcessJobs reads jobs from r, one JSON object per line, and processes them
+// with ProcessReaders. Jobs without a service use the given service. Invalid
+// lines, e.g. malformed JSON, a missing path or a service not fitting the
+// file, are logged and skipped. Since outputs and the state log are keyed by
+// path, a path may only appear once; later jobs for it are skipped as well.
+func (g *Grobid) ProcessJobs(ctx context.Context, r io.Reader, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		inputs   = make(chan NamedReader)
+		readDone = make(chan struct{})
+		readErr  error
+	)
+	go func() {
+		defer close(readDone)
+		defer close(inputs)
+		br := bufio.NewScanner(r)
+		br.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		var (
+			lineno int
+			seen   = make(map[string]int) // path to line
+		)
+		for br.Scan() {
+			lineno++
+			line := bytes.TrimSpace(br.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			job, err := parseJob(line, service)
+			if err != nil {
+				g.logger().Warn("skipping invalid job", "line", lineno, "err", err)
+				continue
+			}
+			key := filepath.Clean(job.Path)
+			if prev, ok := seen[key]; ok {
+				g.logger().Warn("skipping duplicate job", "line", lineno, "path", job.Path, "first", prev)
+				continue
+			}
+			seen[key] = lineno
+			select {
+			case inputs <- NamedReader{
+				Name:    job.Path,
+				Reader:  &lazyFile{name: job.Path},
+				Service: job.Service,
+				Options: job.Options,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = br.Err()
+	}()
+	err := g.ProcessReaders(ctx, inputs, service, numWorkers, rf, opts)
+	// Stop reading, if processing stopped early, and wait for the reader, so
+	// its error can be read safely.
+	cancel()
+	<-readDone
+	if readErr != nil {
+		return readErr
+	}
+	return err
+}
+
+// parseJob parses and validates a single job. If the job does not name a
+// service, the given service is used.
+func parseJob(b []byte, service string) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal(b, &job); err != nil {
+		return nil, err
+	}
+	if job.Path == "" {
+		return nil, errors.New("missing path")
+	}
+	if job.Service == "" {
+		job.Service = service
+	}
+	if !IsValidService(job.Service) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidService, job.Service)
+	}
+	if err := checkInput(job.Service, job.Path); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// lazyFile is a reader that opens the named file on first read, so files can
+// be enqueued without holding on to open file descriptors.
+type lazyFile struct {
+	name string
+	f    *os.File
+	err  error
+}
+
+// open opens the file, if it has not been opened yet.
+func (l *lazyFile) open() error {
+	if l.f == nil && l.err == nil {
+		l.f, l.err = os.Open(l.name)
+	}
+	return l.err
+}
+
+// Read opens the file, if necessary, and reads from it.
+func (l *lazyFile) Read(p []byte) (int, error) {
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	return l.f.Read(p)
+}
+
+// Seek opens the file, if necessary, and sets the offset for the next Read.
+func (l *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	return l.f.Seek(offset, whence)
+}
+
+// Close closes the file, if it has been opened.
+func (l *lazyFile) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// stateFlushInterval is the maximum time between flushes of the state log.
+const stateFlushInterval = 5 * time.Second
+
+// stateLog is an append-only log of processed inputs, one name and SHA1 per
+// line, kept in memory for fast lookups. A nil stateLog is empty and
+// discards all entries.
+type stateLog struct {
+	mu        sync.Mutex
+	seen      map[string]bool
+	f         *os.File
+	w         *bufio.Writer
+	lastFlush time.Time
+}
+
+// openStateLog loads the entries of an existing state file and opens it for
+// appending, creating it if necessary.
+func openStateLog(filename string) (*stateLog, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name, _, _ := strings.Cut(sc.Text(), "\t")
+		if name != "" {
+			seen[name] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &stateLog{
+		seen:      seen,
+		f:         f,
+		w:         bufio.NewWriter(f),
+		lastFlush: time.Now(),
+	}, nil
+}
+
+// contains returns true, if name has been recorded as processed.
+func (s *stateLog) contains(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[name]
+}
+
+// add records name as processed. The log is flushed periodically.
+func (s *stateLog) add(name, sha1hex string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[name] {
+		return nil
+	}
+	s.seen[name] = true
+	if _, err := fmt.Fprintf(s.w, "%s\t%s\n", name, sha1hex); err != nil {
+		return err
+	}
+	if time.Since(s.lastFlush) < stateFlushInterval {
+		return nil
+	}
+	s.lastFlush = time.Now()
+	return s.w.Flush()
+}
+
+// Close flushes and closes the log.
+func (s *stateLog) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
 		return err
 	}
-	close(pathC)
+	return s.f.Close()
+}
+
+// processPaths processes all file paths passed to enqueue by walk, using
+// processNamedReaders. Files are only opened, once a worker is ready for them.
+func (g *Grobid) processPaths(ctx context.Context, service string, numWorkers int, rf ResultFunc, opts *Options, walk func(enqueue func(string) error) error) error {
+	return g.processNamedReaders(ctx, service, numWorkers, rf, opts, func(enqueue func(NamedReader) error) error {
+		return walk(func(path string) error {
+			return enqueue(NamedReader{Name: path, Reader: &lazyFile{name: path}})
+		})
+	})
+}
+
+// processNamedReaders processes all inputs passed to enqueue by walk, using
+// ProcessReaders. It is the common engine for directories and archives. Once
+// ctx is done, enqueue returns the context error, which the walk should pass
+// on to stop early.
+func (g *Grobid) processNamedReaders(ctx context.Context, service string, numWorkers int, rf ResultFunc, opts *Options, walk func(enqueue func(NamedReader) error) error) error {
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The walk stops at the deadline, while inputs in flight finish.
+	walkCtx, cancelWalk := ctx, context.CancelFunc(func() {})
+	if !opts.Deadline.IsZero() {
+		walkCtx, cancelWalk = context.WithDeadline(ctx, opts.Deadline)
+	}
+	defer cancelWalk()
+	var (
+		inputs     = make(chan NamedReader, max(0, opts.WalkBufferSize))
+		walkDone   = make(chan struct{})
+		walkErr    error
+		numDropped int64 // inputs not enqueued, because the deadline passed
+	)
+	go func() {
+		defer close(walkDone)
+		// Closing inputs on any return, including a failed walk, lets the
+		// workers finish.
+		defer close(inputs)
+		walkErr = walk(func(input NamedReader) error {
+			select {
+			case inputs <- input:
+				return nil
+			case <-walkCtx.Done():
+				if ctx.Err() == nil {
+					numDropped++
+				}
+				return walkCtx.Err()
+			}
+		})
+	}()
+	var numCompleted atomic.Int64
+	next := rf
+	rf = func(result *Result, opts *Options) error {
+		numCompleted.Add(1)
+		return next(result, opts)
+	}
+	if opts.FailFast {
+		// Stop the walk as well, not only the workers.
+		next := rf
+		rf = func(result *Result, opts *Options) error {
+			err := next(result, opts)
+			if err != nil {
+				cancel()
+			}
+			return err
+		}
+	}
+	err := g.ProcessReaders(ctx, inputs, service, numWorkers, rf, opts)
+	// If the workers stopped early, stop the walk, too, and wait for it, so
+	// the walk error can be read safely.
+	cancel()
+	<-walkDone
+	if numDropped > 0 {
+		// The deadline passed during the walk, which is not a failure.
+		walkErr = nil
+		var de *DeadlineError
+		if errors.As(err, &de) {
+			de.Remaining += numDropped
+		} else {
+			err = errors.Join(err, &DeadlineError{Completed: numCompleted.Load(), Remaining: numDropped})
+		}
+	}
+	if err != nil && (opts.FailFast || errors.Is(walkErr, context.Canceled)) {
+		return err
+	}
+	return errors.Join(walkErr, err)
+}
+
+// ProcessReaders processes all inputs received from a channel with a number
+// of workers, until the channel is closed. This allows to process inputs
+// from any source, e.g. object storage or archives, without assuming local
+// files. The input name is used as filename and to check whether the input
+// has already been processed. Each result is passed to the ResultFunc and
+// errors are aggregated, unless opts.FailFast is set. Inputs received after
+// opts.Deadline are skipped and reported in a *DeadlineError.
+func (g *Grobid) ProcessReaders(ctx context.Context, inputs <-chan NamedReader, service string, numWorkers int, rf ResultFunc, opts *Options) error {
+	var (
+		errC         = make(chan error)
+		done         = make(chan bool)
+		wg           sync.WaitGroup
+		errList      []error
+		numEnqueued  atomic.Int64 // inputs received
+		numCompleted atomic.Int64 // results passed to the ResultFunc
+		numRemaining atomic.Int64
+		numRetries   atomic.Int64
+	)
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	if opts.Logger == nil && g.Logger != nil {
+		// Let the result writers log like the client.
+		o := *opts
+		o.Logger = g.Logger
+		opts = &o
+	}
+	g.warnUnknownTEICoordinates(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		failOnce sync.Once
+		firstErr error
+	)
+	countRetries := func(result *Result, opts *Options) error {
+		numRetries.Add(int64(result.RetryCount))
+		err := rf(result, opts)
+		numCompleted.Add(1)
+		if err != nil && opts.FailFast {
+			failOnce.Do(func() {
+				firstErr = err
+				cancel()
+			})
+		}
+		return err
+	}
+	var state *stateLog
+	if opts.StateFile != "" {
+		var err error
+		if state, err = openStateLog(opts.StateFile); err != nil {
+			// Let the producer finish, so it does not block forever.
+			go func() {
+				for range inputs {
+				}
+			}()
+			return err
+		}
+	}
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for input := range inputs {
+				numEnqueued.Add(1)
+				if !opts.Deadline.IsZero() && time.Now().After(opts.Deadline) {
+					numRemaining.Add(1)
+					if c, ok := input.Reader.(io.Closer); ok {
+						c.Close()
+					}
+					continue
+				}
+				if opts.FailFast && ctx.Err() != nil {
+					// Drain the remaining inputs, so the producer does
+					// not block.
+					if c, ok := input.Reader.(io.Closer); ok {
+						c.Close()
+					}
+					continue
+				}
+				if err := g.processNamedReader(ctx, input, service, countRetries, opts, state); err != nil {
+					errC <- err
+				}
+				if c, ok := input.Reader.(io.Closer); ok {
+					c.Close()
+				}
+			}
+		}()
+	}
+	go func() {
+		for err := range errC {
+			if err == nil {
+				continue
+			}
+			// aggregate errors in error list
+			errList = append(errList, err)
+		}
+		done <- true
+	}()
 	wg.Wait()
+	if err := state.Close(); err != nil {
+		errC <- err
+	}
 	close(errC)
 	<-done
-	log.Printf("processed %d docs, with %d errors", numProcessed, len(errList))
+	g.logger().Info("processing finished", "enqueued", numEnqueued.Load(), "completed", numCompleted.Load(),
+		"errors", len(errList), "retries", numRetries.Load())
+	if firstErr != nil {
+		return firstErr
+	}
+	if n := numRemaining.Load(); n > 0 {
+		g.logger().Info("deadline passed", "remaining", n)
+		errList = append(errList, &DeadlineError{Completed: numCompleted.Load(), Remaining: n})
+	}
 	if len(errList) > 0 {
 		return errors.Join(errList...)
 	}
 	return nil
 }
 
+// processNamedReader processes a single input and passes the result to the
+// ResultFunc. Successfully processed inputs are recorded in the state log.
+func (g *Grobid) processNamedReader(ctx context.Context, input NamedReader, service string, rf ResultFunc, opts *Options, state *stateLog) error {
+	if !opts.Force && (state.contains(input.Name) || g.isAlreadyProcessed(input.Name, opts)) {
+		g.logger().Info("already processed", "name", input.Name)
+		return nil
+	}
+	var (
+		result  *Result
+		err     error
+		reqOpts = opts
+	)
+	if input.Service != "" {
+		service = input.Service
+	}
+	if input.Options != nil {
+		reqOpts = input.Options.apply(opts)
+	}
+	switch {
+	case service == "processCitationList":
+		result, err = g.processTextReader(ctx, input, input.Name, service, reqOpts)
+	default:
+		if _, ok := input.Reader.(*lazyFile); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = opts.withFileTimeout(ctx, input.Name, g.clientTimeout())
+			defer cancel()
+		}
+		if rs, ok := input.Reader.(io.ReadSeeker); ok {
+			// Files and archive entries can be sent again, so an empty
+			// response can be retried, and hashed for the cache.
+			result, err = g.processPDFFile(ctx, rs, nil, input.Name, service, reqOpts)
+		} else {
+			result, err = g.ProcessPDFReader(ctx, input, input.Name, service, reqOpts)
+		}
+	}
+	if result == nil {
+		result = &Result{
+			// If processing failed, return a pseudo-result
+			// nonetheless, so we still know know about the error
+			// conditions.
+			Filename:   input.Name,
+			StatusCode: -1,
+			Err:        fmt.Errorf("process failed: %w", err),
+		}
+	}
+	if opts.SkipOversize && errors.Is(result.Err, ErrPDFTooLarge) {
+		g.logger().Info("skipping oversize", "name", input.Name)
+		return nil
+	}
+	if opts.Transform != nil && result.IsSuccess() {
+		if err := opts.Transform(result); err != nil {
+			result.Err = fmt.Errorf("transform failed: %w", err)
+		}
+	}
+	if err := rf(result, opts); err != nil {
+		return err
+	}
+	if result.IsSuccess() {
+		return state.add(input.Name, result.SHA1Hex)
+	}
+	return nil
+}
+
+// matchesService returns true, if the file at path is a suitable input for
+// the given service.
+func matchesService(service, path string) bool {
+	v, ok := ServiceForFile(path)
+	return ok && v == service
+}
+
+// checkInput returns an error wrapping ErrUnsupportedInput, if a text or XML
+// file is used with a service, that does not accept it. PDF and unknown
+// types are left for the server to judge.
+func checkInput(service, path string) error {
+	v, ok := ServiceForFile(path)
+	if !ok || v == service || v == "processFulltextDocument" {
+		return nil
+	}
+	return fmt.Errorf("%w: %s can only be processed with %s, not %s",
+		ErrUnsupportedInput, path, v, service)
+}
+
+// ServiceForFile returns the GROBID service suitable for a given file, based
+// on its type. These are the only combinations used when processing
+// directories:
+//
+//	PDF (optionally gzip compressed)  processFulltextDocument
+//	text (.txt), one citation a line  processCitationList
+//	XML (.xml)                        processCitationPatentST36
+//
+// Other services accepting PDF, like processHeaderDocument, can be used
+// with single files. Returns false, if the filetype is not supported.
+func ServiceForFile(path string) (string, bool) {
+	return serviceForType(path, isPDF(path))
+}
+
+// serviceForType implements ServiceForFile for a file with a given name,
+// which has been detected as PDF, or not.
+func serviceForType(path string, pdf bool) (string, bool) {
+	// Note: Following the Python client, which has hardcoded rules for
+	// what service and what filetype fit together.
+	switch {
+	case pdf || isGzippedPDF(path):
+		return "processFulltextDocument", true
+	case isText(path):
+		return "processCitationList", true
+	case isXML(path):
+		return "processCitationPatentST36", true
+	default:
+		return "", false
+	}
+}
+
 // isPDF returns true, if the given file is likely a PDF.
 func isPDF(filename string) bool {
 	mtype, err := mimetype.DetectFile(filename)
@@ -382,6 +2488,20 @@ func isPDF(filename string) bool {
 	return mtype.Is("application/pdf")
 }
 
+// sniffLen is the number of leading bytes used to detect the type of data,
+// which is not read from a file.
+const sniffLen = 3072
+
+// isPDFContent returns true, if the data starting with head is likely a PDF.
+func isPDFContent(head []byte) bool {
+	return mimetype.Detect(head).Is("application/pdf")
+}
+
+// isGzippedPDF returns true, if the filename is likely a gzip compressed PDF.
+func isGzippedPDF(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".pdf.gz")
+}
+
 // isXML returns true, if the filename is likely an XML file.
 func isXML(filename string) bool {
 	return strings.HasSuffix(strings.ToLower(filename), ".xml")
@@ -392,12 +2512,164 @@ func isText(filename string) bool {
 	return strings.HasSuffix(strings.ToLower(filename), ".txt")
 }
 
-// ProcessPDFContext analysis a single PDF, with cancellation options.
+// ProcessPDFContext analysis a single PDF, with cancellation options. If a
+// Cache is configured, the file is hashed first and a cached response for
+// the same service and options is returned without contacting the server.
+// Successful responses are cached.
 func (g *Grobid) ProcessPDFContext(ctx context.Context, filename, service string, opts *Options) (*Result, error) {
-	var started = time.Now()
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	if err := checkInput(service, filename); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = DefaultOptions
+	}
+	g.warnUnknownTEICoordinates(opts)
+	fi, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	ctx, cancel := opts.withFileTimeout(ctx, filename, g.clientTimeout())
+	defer cancel()
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return g.processPDFFile(ctx, f, fi, filename, service, opts)
+}
+
+// processPDFFile sends the file f, starting at its current offset, which
+// must be the beginning of the file, unless a response is found in the
+// cache. Successful responses are cached.
+func (g *Grobid) processPDFFile(ctx context.Context, f io.ReadSeeker, fi fs.FileInfo, filename, service string, opts *Options) (*Result, error) {
+	if g.Cache == nil {
+		return g.sendPDFFile(ctx, f, fi, filename, service, opts)
+	}
+	started := time.Now()
+	sha1hex, err := readerSHA1(f)
+	if err != nil {
+		return nil, err
+	}
+	key := cacheKey(sha1hex, service, opts)
+	if b, ok := g.cache().Get(key); ok {
+		return &Result{
+			Filename:       filename,
+			SHA1Hex:        sha1hex,
+			StatusCode:     http.StatusOK,
+			Body:           b,
+			ProcessingTime: time.Since(started),
+		}, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	result, err := g.sendPDFFile(ctx, f, fi, filename, service, opts)
+	if err != nil {
 		return nil, err
 	}
+	if result.IsSuccess() && !result.Streamed {
+		g.cache().Put(key, result.Body)
+	}
+	return result, nil
+}
+
+// emptyBodyAttempts is the maximum number of requests made for a file with
+// Options.RetryOnEmptyBody.
+const emptyBodyAttempts = 3
+
+// emptyBodyWait is the time to wait before repeating a request, that
+// returned an empty body; it doubles with each further attempt.
+var emptyBodyWait = time.Second
+
+// isEmptyBody returns true, if the server responded successfully, but sent
+// nothing back.
+func isEmptyBody(r *Result) bool {
+	return r.StatusCode == http.StatusOK && r.Err == nil && len(r.Body) == 0 && !r.Streamed
+}
+
+// sendPDFFile sends the file f, starting at its current offset, which must
+// be the beginning of the file. With RetryOnEmptyBody, the request is
+// repeated after an empty response, until emptyBodyAttempts are used up.
+// The repetitions are included in Result.RetryCount. Upload progress is only
+// reported, if file info is given.
+func (g *Grobid) sendPDFFile(ctx context.Context, f io.ReadSeeker, fi fs.FileInfo, filename, service string, opts *Options) (*Result, error) {
+	var (
+		retries int
+		wait    = emptyBodyWait
+	)
+	for attempt := 1; ; attempt++ {
+		var src io.Reader = f
+		if opts.UploadProgress != nil && fi != nil {
+			src = &progressReader{r: f, total: fi.Size(), fn: opts.UploadProgress}
+		}
+		result, err := g.ProcessPDFReader(ctx, src, filename, service, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.RetryCount += retries
+		if !opts.RetryOnEmptyBody || !isEmptyBody(result) {
+			return result, nil
+		}
+		if attempt == emptyBodyAttempts {
+			result.Err = ErrEmptyBody
+			return result, nil
+		}
+		g.logger().Warn("empty response body, retrying", "name", filename, "attempt", attempt)
+		retries = result.RetryCount + 1
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// progressReader reports the number of bytes read so far, along with the
+// expected total, after each read.
+type progressReader struct {
+	r     io.Reader
+	sent  int64
+	total int64
+	fn    func(sent, total int64)
+}
+
+// Read reads from the underlying reader and reports progress.
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.fn(p.sent, p.total)
+	}
+	return n, err
+}
+
+// readerSHA1 returns the hex encoded SHA1 of the data read from r, which is
+// decompressed first, if it is gzip compressed, like ProcessPDFReader does.
+func readerSHA1(r io.Reader) (string, error) {
+	src, err := maybeGunzip(r)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// errUploadAborted is used to stop an upload, after the server responded.
+var errUploadAborted = errors.New("upload aborted")
+
+// ProcessPDFReader analyses a single PDF read from r. The filename is sent to
+// the server and is recorded in the result. Gzip compressed input is
+// decompressed transparently; the SHA1 is computed over the decompressed
+// bytes.
+func (g *Grobid) ProcessPDFReader(ctx context.Context, r io.Reader, filename, service string, opts *Options) (*Result, error) {
+	var started = time.Now()
 	if opts == nil {
 		opts = DefaultOptions
 	}
@@ -412,24 +2684,26 @@ func (g *Grobid) ProcessPDFContext(ctx context.Context, filename, service string
 		pr, pw = io.Pipe()
 		mw     = multipart.NewWriter(pw)
 		h      = sha1.New()
-		errC   = make(chan error)
+		errC   = make(chan error, 1)
 	)
 	go func() {
 		defer close(errC)
-		f, err := os.Open(filename)
+		src, err := maybeGunzip(r)
 		if err != nil {
+			pw.CloseWithError(err)
 			errC <- err
 			return
 		}
-		defer f.Close()
 		opts.writeFields(mw)
-		part, err := mw.CreateFormFile("input", filepath.Base(filename))
+		part, err := mw.CreateFormFile(opts.inputFieldName(), strings.TrimSuffix(filepath.Base(filename), ".gz"))
 		if err != nil {
+			pw.CloseWithError(err)
 			errC <- err
 			return
 		}
-		tee := io.TeeReader(f, h)
+		tee := io.TeeReader(src, h)
 		if _, err := io.Copy(part, tee); err != nil {
+			pw.CloseWithError(err)
 			errC <- err
 			return
 		}
@@ -443,45 +2717,295 @@ func (g *Grobid) ProcessPDFContext(ctx context.Context, filename, service string
 		}
 		errC <- nil
 	}()
+	var attempts atomic.Int64
+	ctx = context.WithValue(ctx, attemptCounterKey{}, &attempts)
 	req, err := http.NewRequestWithContext(ctx, "POST", serviceURL, pr)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", mw.FormDataContentType())
-	req.Header.Set("Accept", "application/xml")
+	req.Header.Set("Accept", opts.acceptHeader(service))
+	if opts.TraceFunc != nil {
+		opts.TraceFunc(req, opts.FormFields())
+	}
 	resp, err := g.Client.Do(req)
+	// If the request failed or the server responded with an error, possibly
+	// before reading the whole body, closing the reading side of the pipe
+	// makes pending writes fail, so the upload goroutine cannot block. It
+	// returns exactly one value. If reading the input fails, the pipe is
+	// closed with that error, so the request does not hang.
+	if err != nil || resp.StatusCode != http.StatusOK {
+		pr.CloseWithError(errUploadAborted)
+	}
+	uploadErr := <-errC
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	// This works, because the copy goroutine returns exactly one value. If
-	// there is an error in opening the file, we may not see this error. TODO:
-	// test case.
-	if err := <-errC; err != nil {
-		return nil, err
+	var aborted bool
+	switch {
+	case errors.Is(uploadErr, errUploadAborted), errors.Is(uploadErr, io.ErrClosedPipe):
+		// The transport may close the body itself, when the server
+		// responds early.
+		aborted = true
+	case uploadErr != nil:
+		return nil, uploadErr
 	}
-	b, err := io.ReadAll(resp.Body)
+	result := &Result{
+		Filename:      filename,
+		StatusCode:    resp.StatusCode,
+		ServerHeaders: serverHeaders(resp.Header),
+		ContentType:   resp.Header.Get("Content-Type"),
+	}
+	if !aborted {
+		// The checksum is only known, if the whole input has been read.
+		result.SHA1Hex = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	if n := attempts.Load(); n > 1 {
+		result.RetryCount = int(n - 1)
+	}
+	br := bufio.NewReader(resp.Body)
+	if opts.StreamToDisk && opts.NameFunc == nil && resp.StatusCode == http.StatusOK &&
+		opts.acceptHeader(service) == "application/xml" {
+		// Peek errors surface when reading the body below.
+		head, _ := br.Peek(512)
+		if isXMLResponse(result.ContentType, head) {
+			dst := outputFilenameSHA1(filename, result.SHA1Hex, opts)
+			n, err := writeFileFrom(dst, br)
+			if err != nil {
+				return nil, err
+			}
+			result.ProcessingTime = time.Since(started)
+			if n > 0 {
+				result.OutputPath, result.Streamed = dst, true
+				return result, nil
+			}
+			// Nothing to stream, remove the empty file, so this
+			// is treated like an empty response.
+			if err := os.Remove(dst); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+	}
+	b, err := io.ReadAll(br)
 	if err != nil {
 		return nil, err
 	}
-	result := &Result{
-		Filename:       filename,
-		Body:           b,
-		SHA1Hex:        fmt.Sprintf("%x", h.Sum(nil)),
-		StatusCode:     resp.StatusCode,
-		ProcessingTime: time.Since(started),
+	result.Body = b
+	result.ProcessingTime = time.Since(started)
+	switch {
+	case isOversize(resp.StatusCode, b):
+		result.Err = ErrPDFTooLarge
+	case resp.StatusCode == http.StatusOK &&
+		opts.acceptHeader(service) == "application/xml" &&
+		!isXMLResponse(result.ContentType, b):
+		result.Err = ErrUnexpectedContentType
 	}
 	return result, nil
 }
 
+// writeFileFrom writes all data from r to a file, which only appears at its
+// final location after all data has been written.
+func writeFileFrom(filename string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".grobid-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), filename)
+}
+
+// isHTML returns true, if the content type or the body indicate an HTML
+// document.
+func isHTML(contentType string, body []byte) bool {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt == "text/html" {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// isXMLResponse returns true, if the content type is an XML media type, like
+// application/xml, text/xml or application/tei+xml, or the body looks like
+// XML. HTML pages are never XML. An empty body without content type is
+// accepted, so it is handled like any other empty response.
+func isXMLResponse(contentType string, body []byte) bool {
+	if isHTML(contentType, body) {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")) {
+		return true
+	}
+	body = bytes.TrimLeft(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(body) == 0 {
+		return contentType == ""
+	}
+	return body[0] == '<'
+}
+
+// maybeGunzip returns a reader for the decompressed data, if r starts with
+// the gzip magic bytes, otherwise a reader for the unchanged data.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(b) == 2 && b[0] == 0x1f && b[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// acceptHeader returns the media type to request for a given service.
+func acceptHeader(service string) string {
+	if service == "processFulltextAssetDocument" {
+		return "application/zip"
+	}
+	return "application/xml"
+}
+
+// oversizeMessages are the exception states GROBID reports, with status
+// 500, for documents exceeding the blocksMax or tokensMax limits of its
+// configuration.
+var oversizeMessages = []string{"[TOO_MANY_BLOCKS]", "[TOO_MANY_TOKENS]"}
+
+// isOversize returns true, if the server rejected the input for its size,
+// either with status 413, as the server or a proxy does for a too large
+// upload, or with one of GROBID's messages about the document size. Other
+// errors are not matched, even if they mention a size.
+func isOversize(statusCode int, body []byte) bool {
+	switch statusCode {
+	case http.StatusRequestEntityTooLarge:
+		return true
+	case http.StatusInternalServerError:
+		for _, v := range oversizeMessages {
+			if bytes.Contains(body, []byte(v)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ProcessPDF processes a single PDF with given options. Result contains the
 // HTTP status code, indicating success or failure.
 func (g *Grobid) ProcessPDF(filename, service string, opts *Options) (*Result, error) {
 	return g.ProcessPDFContext(context.Background(), filename, service, opts)
 }
 
+// ProcessPDFURL downloads a PDF and processes it with given options. The
+// result filename is set to the URL. DefaultResultWriter names the output
+// after the host and path of the URL and a short hash of it, so URLs with
+// the same base name get separate files. If the download does not succeed,
+// an error wrapping ErrDownloadFailed is returned.
+func (g *Grobid) ProcessPDFURL(ctx context.Context, pdfURL, service string, opts *Options) (*Result, error) {
+	if opts != nil {
+		g.warnUnknownTEICoordinates(opts)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", pdfURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s responded with %d", ErrDownloadFailed, pdfURL, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return g.ProcessPDFReader(ctx, bytes.NewReader(b), pdfURL, service, opts)
+}
+
+// AssetResult is the result of a fulltext processing with asset extraction.
+// For successful requests, it contains the TEI document and the extracted
+// assets, like figure images, keyed by filename.
+type AssetResult struct {
+	*Result
+	TEI    []byte
+	Assets map[string][]byte
+}
+
+// ProcessPDFAssets processes a single PDF with the
+// processFulltextAssetDocument service, which returns a ZIP file containing
+// the TEI document and the extracted assets. The server must support this
+// endpoint, which is not available in all GROBID distributions. If the
+// server does not respond with HTTP 200, only the embedded Result is set.
+func (g *Grobid) ProcessPDFAssets(ctx context.Context, filename string, opts *Options) (*AssetResult, error) {
+	result, err := g.ProcessPDFContext(ctx, filename, "processFulltextAssetDocument", opts)
+	if err != nil {
+		return nil, err
+	}
+	ar := &AssetResult{Result: result}
+	if result.StatusCode != http.StatusOK {
+		return ar, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(result.Body), int64(len(result.Body)))
+	if err != nil {
+		return nil, err
+	}
+	ar.Assets = make(map[string][]byte)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if ar.TEI == nil && strings.HasSuffix(f.Name, ".xml") {
+			ar.TEI = b
+			continue
+		}
+		ar.Assets[f.Name] = b
+	}
+	return ar, nil
+}
+
 // ProcessText processes a single text file with given options.
 func (g *Grobid) ProcessText(filename, service string, opts *Options) (*Result, error) {
+	return g.ProcessTextContext(context.Background(), filename, service, opts)
+}
+
+// ProcessTextContext processes a single text file, with cancellation options.
+func (g *Grobid) ProcessTextContext(ctx context.Context, filename, service string, opts *Options) (*Result, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return g.processTextReader(ctx, f, filename, service, opts)
+}
+
+// processTextReader processes citations read from r, one per line. If
+// CitationBatchSize is set, the citations are sent in batches and the
+// responses are merged into a single document, in the original order.
+func (g *Grobid) processTextReader(ctx context.Context, r io.Reader, filename, service string, opts *Options) (*Result, error) {
 	started := time.Now()
 	if !IsValidService(service) {
 		return nil, ErrInvalidService
@@ -489,6 +3013,27 @@ func (g *Grobid) ProcessText(filename, service string, opts *Options) (*Result,
 	if opts == nil {
 		opts = DefaultOptions
 	}
+	lines, err := parseLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var result *Result
+	if opts.CitationBatchSize > 0 && len(lines) > opts.CitationBatchSize {
+		result, err = g.postCitationBatches(ctx, service, lines, opts)
+	} else {
+		result, err = g.postCitations(ctx, service, lines, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Filename = filename
+	result.ProcessingTime = time.Since(started)
+	return result, nil
+}
+
+// postCitations sends a list of citations to the service. The response may
+// be TEI or, depending on the accept header, JSON.
+func (g *Grobid) postCitations(ctx context.Context, service string, lines []string, opts *Options) (*Result, error) {
 	serviceURL, err := url.JoinPath(g.Server, "api", service)
 	if err != nil {
 		return nil, err
@@ -502,46 +3047,256 @@ func (g *Grobid) ProcessText(filename, service string, opts *Options) (*Result,
 			Citations            []string `json:"citations"`
 		}
 	)
-	f, err := os.Open(filename)
+	payload.Citations = lines
+	if level := opts.consolidateCitationsLevel(); level > 0 {
+		payload.ConsolidateCitations = strconv.Itoa(level)
+	}
+	if level := opts.consolidateHeaderLevel(); level > 0 {
+		payload.ConsolidateHeader = strconv.Itoa(level)
+	}
+	if err := enc.Encode(payload); err != nil {
+		return nil, err
+	}
+	send := func(body []byte, encoding string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", serviceURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", opts.acceptHeader(service))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		return g.Client.Do(req)
+	}
+	var (
+		resp *http.Response
+		body = buf.Bytes()
+	)
+	if opts.CompressRequest && len(body) > compressThreshold {
+		compressed, err := gzipBytes(body)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = send(compressed, "gzip"); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnsupportedMediaType {
+			g.logger().Warn("compressed request rejected, retrying uncompressed", "service", service)
+			resp.Body.Close()
+			resp = nil
+		}
+	}
+	if resp == nil {
+		if resp, err = send(body, ""); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	lines, err := parseLines(f)
+	result := &Result{
+		StatusCode:    resp.StatusCode,
+		Body:          b,
+		ServerHeaders: serverHeaders(resp.Header),
+		ContentType:   resp.Header.Get("Content-Type"),
+	}
+	return result, nil
+}
+
+// compressThreshold is the size in bytes above which requests are compressed
+// with Options.CompressRequest. Compressing small requests does not pay off.
+const compressThreshold = 64 << 10
+
+// gzipBytes returns b gzip compressed.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postCitationBatches sends citations in batches of CitationBatchSize,
+// concurrently, and merges the responses. If any batch fails, the result of
+// the first failed batch is returned.
+func (g *Grobid) postCitationBatches(ctx context.Context, service string, lines []string, opts *Options) (*Result, error) {
+	var batches [][]string
+	for i := 0; i < len(lines); i += opts.CitationBatchSize {
+		batches = append(batches, lines[i:min(i+opts.CitationBatchSize, len(lines))])
+	}
+	numWorkers := opts.CitationBatchWorkers
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	var (
+		results = make([]*Result, len(batches))
+		errs    = make([]error, len(batches))
+		sem     = make(chan struct{}, numWorkers)
+		wg      sync.WaitGroup
+	)
+	for i, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = g.postCitations(ctx, service, batch, opts)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	bodies := make([][]byte, len(results))
+	for i, r := range results {
+		if r.StatusCode != http.StatusOK {
+			r.Err = fmt.Errorf("citation batch %d of %d failed", i+1, len(batches))
+			return r, nil
+		}
+		bodies[i] = r.Body
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if isJSON(results[0].ContentType) {
+		b, err = mergeCitationListsJSON(bodies)
+	} else {
+		b, err = mergeCitationLists(bodies)
+	}
 	if err != nil {
 		return nil, err
 	}
-	payload.Citations = lines
-	if opts.ConsolidateCitations {
-		payload.ConsolidateCitations = "1"
+	result := &Result{
+		StatusCode:    http.StatusOK,
+		Body:          b,
+		ServerHeaders: results[0].ServerHeaders,
+		ContentType:   results[0].ContentType,
 	}
-	if opts.ConsolidateHeader {
-		payload.ConsolidateHeader = "1"
+	return result, nil
+}
+
+// mergeCitationListsJSON concatenates the citations of all JSON documents
+// into a single JSON array, keeping their order. Null elements are kept, so
+// the position of each citation still matches its input line.
+func mergeCitationListsJSON(bodies [][]byte) ([]byte, error) {
+	var citations []*tei.GrobidBiblio
+	for _, b := range bodies {
+		cs, err := tei.ParseCitationsJSON(b)
+		if err != nil {
+			return nil, err
+		}
+		citations = append(citations, cs...)
 	}
-	if err := enc.Encode(payload); err != nil {
+	for i, c := range citations {
+		if c != nil {
+			c.Index = i
+		}
+	}
+	return json.Marshal(citations)
+}
+
+// mergeCitationLists appends the citations of all documents to the list of
+// citations of the first document, keeping their order.
+func mergeCitationLists(bodies [][]byte) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(bodies[0]); err != nil {
+		return nil, err
+	}
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("merge: empty citation list document")
+	}
+	var parent *etree.Element
+	switch el := root.FindElement(".//biblStruct"); {
+	case root.Tag == "biblStruct":
+		// A single citation, wrap it into a list.
+		doc = etree.NewDocument()
+		parent = doc.CreateElement("listBibl")
+		parent.AddChild(root)
+	case el != nil:
+		parent = el.Parent()
+	default:
+		parent = root
+	}
+	for _, body := range bodies[1:] {
+		d := etree.NewDocument()
+		if err := d.ReadFromBytes(body); err != nil {
+			return nil, err
+		}
+		root := d.Root()
+		if root == nil {
+			continue
+		}
+		if root.Tag == "biblStruct" {
+			parent.AddChild(root)
+			continue
+		}
+		for _, el := range root.FindElements(".//biblStruct") {
+			parent.AddChild(el)
+		}
+	}
+	return doc.WriteToBytes()
+}
+
+// postForm sends url encoded form values to a service and returns the
+// response body. Any response status other than 200 is an error.
+func (g *Grobid) postForm(service string, values url.Values) ([]byte, error) {
+	serviceURL, err := url.JoinPath(g.Server, "api", service)
+	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", serviceURL, &buf)
+	req, err := http.NewRequest("POST", serviceURL, strings.NewReader(values.Encode()))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/xml")
 	resp, err := g.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	b, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with: %v", http.StatusText(resp.StatusCode))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ProcessDate normalizes a free text date, like "March 4th, 2021", and
+// returns the normalized date from the "when" attribute of the response,
+// e.g. "2021-03-04". Options are currently not used.
+func (g *Grobid) ProcessDate(s string, _ *Options) (string, error) {
+	b, err := g.postForm("processDate", url.Values{"date": {s}})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	result := &Result{
-		Filename:       filename,
-		StatusCode:     resp.StatusCode,
-		Body:           b,
-		ProcessingTime: time.Since(started),
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(b); err != nil {
+		return "", err
 	}
-	return result, nil
+	for _, el := range doc.FindElements("//date") {
+		if when := el.SelectAttrValue("when", ""); when != "" {
+			return when, nil
+		}
+	}
+	return "", fmt.Errorf("no normalized date found for %q", s)
+}
+
+// ProcessNames parses a string of one or more author names, e.g. "John F.
+// Doe and Jane Roe", into structured names. Options are currently not used.
+func (g *Grobid) ProcessNames(s string, _ *Options) ([]*tei.GrobidAuthor, error) {
+	b, err := g.postForm("processHeaderNames", url.Values{"names": {s}})
+	if err != nil {
+		return nil, err
+	}
+	return tei.ParsePersNames(string(b)), nil
 }
 
 // parseLines reads lines in a file into a given string slice.
@@ -3,16 +3,41 @@
 package grobidclient
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/beevik/etree"
+	"github.com/miku/grobidclient/tei"
+	"github.com/sethgrid/pester"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -63,110 +88,1878 @@ func TestProcessPDF(t *testing.T) {
 	}
 }
 
-func TestParseLines(t *testing.T) {
+func TestProcessPDFTooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "[TOO_MANY_TOKENS] The document has too many tokens")
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	result, err := grobid.ProcessPDF("testdata/pdf/1906.11632.pdf", "processFulltextDocument", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.Err != ErrPDFTooLarge {
+		t.Fatalf("got %v, want %v", result.Err, ErrPDFTooLarge)
+	}
+	var called bool
+	rf := func(*Result, *Options) error {
+		called = true
+		return nil
+	}
+	opts := &Options{SkipOversize: true}
+	if err := grobid.ProcessDirRecursive("testdata/pdf", "processFulltextDocument", 2, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if called {
+		t.Fatalf("oversize results should be skipped")
+	}
+}
+
+func TestProcessReaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		fmt.Fprintf(w, "<TEI>%s</TEI>", b)
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	inputs := make(chan NamedReader)
+	go func() {
+		defer close(inputs)
+		for _, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+			inputs <- NamedReader{Name: name, Reader: strings.NewReader(name)}
+		}
+	}()
+	var (
+		mu      sync.Mutex
+		results = make(map[string]string)
+	)
+	rf := func(r *Result, _ *Options) error {
+		mu.Lock()
+		defer mu.Unlock()
+		results[r.Filename] = r.StringBody()
+		return r.Err
+	}
+	if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 2, rf, &Options{}); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := map[string]string{
+		"a.pdf": "<TEI>a.pdf</TEI>",
+		"b.pdf": "<TEI>b.pdf</TEI>",
+		"c.pdf": "<TEI>c.pdf</TEI>",
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("got %v, want %v", results, want)
+	}
+}
+
+func TestProcessReadersStateFile(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, fh, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requested = append(requested, fh.Filename)
+		mu.Unlock()
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	var (
+		grobid    = &Grobid{Server: ts.URL, Client: ts.Client()}
+		stateFile = filepath.Join(t.TempDir(), "state.log")
+		opts      = &Options{StateFile: stateFile}
+		rf        = func(r *Result, _ *Options) error { return nil }
+	)
+	if err := os.WriteFile(stateFile, []byte("a.pdf\t8843d7f92416211de9ebb963ff4ce28125932878\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	inputs := make(chan NamedReader)
+	go func() {
+		defer close(inputs)
+		for _, name := range []string{"a.pdf", "b.pdf"} {
+			inputs <- NamedReader{Name: name, Reader: strings.NewReader(name)}
+		}
+	}()
+	if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 1, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := []string{"b.pdf"}; !reflect.DeepEqual(requested, want) {
+		t.Fatalf("got %v, want %v", requested, want)
+	}
+	b, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := "a.pdf\t8843d7f92416211de9ebb963ff4ce28125932878\nb.pdf\t"; !strings.HasPrefix(string(b), want) {
+		t.Fatalf("got %q, want prefix %q", b, want)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	var buf bytes.Buffer
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client(), Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	inputs := make(chan NamedReader, 1)
+	inputs <- NamedReader{Name: "a.pdf", Reader: strings.NewReader("%PDF-1.4")}
+	close(inputs)
+	opts := &Options{Verbose: true, OutputDir: t.TempDir()}
+	rf := func(result *Result, opts *Options) error {
+		if err := DebugResultWriter(result, opts); err != nil {
+			return err
+		}
+		return DefaultResultWriter(result, opts)
+	}
+	if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 1, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	// The result writers log through the client logger as well.
+	for _, want := range []string{"processing finished", "msg=result", "name=a.pdf", "msg=done"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("got %q, want %q", buf.String(), want)
+		}
+	}
+	if opts.Logger != nil {
+		t.Fatalf("got %v, want options unchanged", opts.Logger)
+	}
+}
+
+func TestProcessReadersProgressLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
 	var cases = []struct {
-		about  string
-		r      io.Reader
-		result []string
-		err    error
+		about string
+		opts  *Options
+		want  string
 	}{
-		{
-			about:  "nothing to read",
-			r:      strings.NewReader(``),
-			result: nil,
-			err:    nil,
-		},
-		{
-			about:  "single line",
-			r:      strings.NewReader("1\n"),
-			result: []string{"1"},
-			err:    nil,
-		},
-		{
-			about:  "just an empty line",
-			r:      strings.NewReader("\n"),
-			result: nil,
-			err:    nil,
+		{"all completed", &Options{}, "enqueued=3 completed=3"},
+		{"deadline passed", &Options{Deadline: time.Now().Add(-time.Minute)}, "enqueued=3 completed=0"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		grobid := &Grobid{Server: ts.URL, Client: ts.Client(), Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+		inputs := make(chan NamedReader)
+		go func() {
+			defer close(inputs)
+			for _, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+				inputs <- NamedReader{Name: name, Reader: strings.NewReader("%PDF-1.4")}
+			}
+		}()
+		rf := func(*Result, *Options) error { return nil }
+		var de *DeadlineError
+		if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 2, rf, c.opts); err != nil && !errors.As(err, &de) {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !strings.Contains(buf.String(), c.want) {
+			t.Fatalf("[%s] got %q, want %q", c.about, buf.String(), c.want)
+		}
+	}
+}
+
+func TestProcessReadersTransform(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("<TEI>secret</TEI>"))
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	inputs := make(chan NamedReader)
+	go func() {
+		defer close(inputs)
+		for _, name := range []string{"a.pdf", "b.pdf"} {
+			inputs <- NamedReader{Name: name, Reader: strings.NewReader("%PDF-1.4")}
+		}
+	}()
+	errTransform := errors.New("cannot transform")
+	opts := &Options{
+		Transform: func(r *Result) error {
+			if r.Filename == "b.pdf" {
+				return errTransform
+			}
+			r.Body = bytes.ReplaceAll(r.Body, []byte("secret"), []byte("xxx"))
+			return nil
 		},
-		{
-			about:  "just an empty line",
-			r:      strings.NewReader("1\n2\n3  \n"),
-			result: []string{"1", "2", "3"},
-			err:    nil,
+	}
+	var (
+		mu      sync.Mutex
+		results = make(map[string]*Result)
+	)
+	rf := func(r *Result, _ *Options) error {
+		mu.Lock()
+		defer mu.Unlock()
+		results[r.Filename] = r
+		return nil
+	}
+	if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 2, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if got := results["a.pdf"].StringBody(); got != "<TEI>xxx</TEI>" {
+		t.Fatalf("got %q, want transformed body", got)
+	}
+	if r := results["b.pdf"]; !errors.Is(r.Err, errTransform) || r.IsSuccess() {
+		t.Fatalf("got %v, want %v", r.Err, errTransform)
+	}
+}
+
+func TestProcessReadersDeadline(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	inputs := make(chan NamedReader)
+	go func() {
+		defer close(inputs)
+		for _, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+			inputs <- NamedReader{Name: name, Reader: strings.NewReader(name)}
+		}
+	}()
+	opts := &Options{Deadline: time.Now().Add(-time.Second)}
+	rf := func(r *Result, _ *Options) error {
+		t.Fatalf("unexpected result after deadline: %v", r.Filename)
+		return nil
+	}
+	err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 2, rf, opts)
+	var de *DeadlineError
+	if !errors.As(err, &de) {
+		t.Fatalf("got %v, want DeadlineError", err)
+	}
+	if de.Completed != 0 || de.Remaining != 3 {
+		t.Fatalf("got %d completed, %d remaining, want 0, 3", de.Completed, de.Remaining)
+	}
+	if n := numRequests.Load(); n != 0 {
+		t.Fatalf("got %d requests, want 0", n)
+	}
+	// A directory walk stops at the deadline.
+	opts = &Options{Deadline: time.Now().Add(-time.Second)}
+	err = grobid.ProcessDirRecursive("testdata/pdf", "processFulltextDocument", 1, rf, opts)
+	if !errors.As(err, &de) {
+		t.Fatalf("got %v, want DeadlineError", err)
+	}
+	if de.Completed != 0 || de.Remaining == 0 {
+		t.Fatalf("got %d completed, %d remaining, want 0, >0", de.Completed, de.Remaining)
+	}
+	if n := numRequests.Load(); n != 0 {
+		t.Fatalf("got %d requests, want 0", n)
+	}
+}
+
+func TestServerHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "processCitationList") {
+			w.Header().Set("X-Response-Time", "1234ms")
+		}
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	result, err := grobid.ProcessPDF("testdata/pdf/1906.11632.pdf", "processFulltextDocument", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.ServerHeaders != nil {
+		t.Fatalf("got %v, want nil", result.ServerHeaders)
+	}
+	result, err = grobid.ProcessText("testdata/small.xml", "processCitationList", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := "1234ms"; result.ServerHeaders.Get("X-Response-Time") != want {
+		t.Fatalf("got %v, want %v", result.ServerHeaders, want)
+	}
+}
+
+func TestProcessPDFAssets(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct{ name, body string }{
+		{"1906.11632.tei.xml", "<TEI/>"},
+		{"image-1.png", "png"},
+		{"image-2.jpg", "jpg"},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		io.WriteString(w, f.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/zip" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	result, err := grobid.ProcessPDFAssets(context.Background(), "testdata/pdf/1906.11632.pdf", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", result.StatusCode)
+	}
+	if want := "<TEI/>"; string(result.TEI) != want {
+		t.Fatalf("got %s, want %v", result.TEI, want)
+	}
+	want := map[string][]byte{
+		"image-1.png": []byte("png"),
+		"image-2.jpg": []byte("jpg"),
+	}
+	if !reflect.DeepEqual(result.Assets, want) {
+		t.Fatalf("got %v, want %v", result.Assets, want)
+	}
+}
+
+func TestProcessDirRecursiveFileFilter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, name := range []string{"a.pdf", "raw/b.pdf", "c/d.pdf"} {
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(dst, b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var (
+		mu        sync.Mutex
+		filenames []string
+	)
+	rf := func(r *Result, _ *Options) error {
+		mu.Lock()
+		defer mu.Unlock()
+		filenames = append(filenames, r.Filename)
+		return nil
+	}
+	opts := &Options{
+		FileFilter: func(path string, info fs.FileInfo) bool {
+			return !(info.IsDir() && info.Name() == "raw")
 		},
 	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	if err := grobid.ProcessDirRecursive(dir, "processFulltextDocument", 2, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	sort.Strings(filenames)
+	want := []string{filepath.Join(dir, "a.pdf"), filepath.Join(dir, "c/d.pdf")}
+	if !reflect.DeepEqual(filenames, want) {
+		t.Fatalf("got %v, want %v", filenames, want)
+	}
+}
+
+func TestFilterFileIncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.pdf", "supplementary_1.pdf", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var cases = []struct {
+		about   string
+		include []string
+		exclude []string
+		name    string
+		skip    bool
+		err     error
+	}{
+		{"no patterns", nil, nil, "supplementary_1.pdf", false, nil},
+		{"excluded", nil, []string{"supplementary_*.pdf"}, "supplementary_1.pdf", true, nil},
+		{"not excluded", nil, []string{"supplementary_*.pdf"}, "a.pdf", false, nil},
+		{"included", []string{"*.pdf"}, nil, "a.pdf", false, nil},
+		{"not included", []string{"*.pdf"}, nil, "notes.txt", true, nil},
+		{"exclude wins", []string{"*.pdf"}, []string{"supplementary_*"}, "supplementary_1.pdf", true, nil},
+		{"bad pattern", nil, []string{"["}, "a.pdf", true, filepath.ErrBadPattern},
+	}
 	for _, c := range cases {
-		lines, err := parseLines(c.r)
-		if err != c.err {
-			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		path := filepath.Join(dir, c.name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
 		}
-		if !reflect.DeepEqual(lines, c.result) {
-			t.Fatalf("[%s] got %v (%d), want %v (%d)", c.about, lines, len(lines), c.result, len(c.result))
+		opts := &Options{Include: c.include, Exclude: c.exclude}
+		skip, err := opts.filterFile(path, info)
+		if skip != c.skip || !errors.Is(err, c.err) {
+			t.Fatalf("[%s] got %v %v, want %v %v", c.about, skip, err, c.skip, c.err)
 		}
 	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	opts := &Options{Include: []string{"*.pdf"}}
+	if skip, _ := opts.filterFile(dir, info); skip {
+		t.Fatalf("got %v, want directories not to be filtered by patterns", skip)
+	}
 }
 
-func TestDefaultResultWriter(t *testing.T) {
-	var cases = []struct {
-		about  string
+// countingReader counts the bytes read from an underlying reader.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+func TestProcessPDFReaderEarlyResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Respond without reading the body.
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "rejected")
+	}))
+	defer ts.Close()
+	const size = 64 << 20
+	input := &countingReader{
+		r: io.MultiReader(strings.NewReader("%PDF-1.4\n"), io.LimitReader(zeroReader{}, size)),
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	done := make(chan struct{})
+	var (
 		result *Result
-		opts   *Options
-		dst    string // destination file
 		err    error
+	)
+	go func() {
+		defer close(done)
+		result, err = grobid.ProcessPDFReader(context.Background(), input, "a.pdf", "processFulltextDocument", &Options{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload did not terminate")
+	}
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != http.StatusBadRequest || result.StringBody() != "rejected" {
+		t.Fatalf("got %v, want 400 and body", result)
+	}
+	if result.SHA1Hex != "" {
+		t.Fatalf("got %v, want no checksum for incomplete upload", result.SHA1Hex)
+	}
+	// The upload goroutine has finished, so the input is not read anymore.
+	n := input.n.Load()
+	time.Sleep(50 * time.Millisecond)
+	if input.n.Load() != n || n >= size {
+		t.Fatalf("got %d bytes read, want upload to stop early", input.n.Load())
+	}
+}
+
+// zeroReader is an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestProcessJobs(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]string) // service to consolidateHeader
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests[path.Base(r.URL.Path)] = r.FormValue("consolidateHeader")
+		mu.Unlock()
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	jobs := strings.Join([]string{
+		`{"path": "testdata/pdf/1906.11632.pdf"}`,
+		`{"path": "testdata/pdf/1906.02444.pdf", "service": "processHeaderDocument", "options": {"consolidateHeader": 2}}`,
+		`{"path": "testdata/pdf/1906.02444.pdf", "service": "processUnknown"}`,
+		`{"path": "./testdata/pdf/1906.11632.pdf", "service": "processHeaderDocument"}`,
+		`{"path": "testdata/txt/a.txt", "service": "processHeaderDocument"}`,
+		`{"service": "processHeaderDocument"}`,
+		`not json`,
+		``,
+	}, "\n")
+	var (
+		numResults atomic.Int64
+		rf         = func(r *Result, opts *Options) error {
+			numResults.Add(1)
+			if opts.ConsolidateHeaderLevel != 1 {
+				t.Errorf("got %v, want batch options passed to result func", opts.ConsolidateHeaderLevel)
+			}
+			return nil
+		}
+		opts   = &Options{ConsolidateHeaderLevel: 1, Force: true}
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+	)
+	if err := grobid.ProcessJobs(context.Background(), strings.NewReader(jobs), "processFulltextDocument", 2, rf, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if numResults.Load() != 2 {
+		t.Fatalf("got %v results, want 2", numResults.Load())
+	}
+	want := map[string]string{
+		"processFulltextDocument": "1",
+		"processHeaderDocument":   "2",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("got %v, want %v", requests, want)
+	}
+	// A state log, that cannot be opened, stops processing, while jobs
+	// are still being read.
+	var many []string
+	for i := 0; i < 1000; i++ {
+		many = append(many, fmt.Sprintf(`{"path": "testdata/pdf/%d.pdf"}`, i))
+	}
+	opts = &Options{StateFile: filepath.Join(t.TempDir(), "missing", "state.log")}
+	err := grobid.ProcessJobs(context.Background(), strings.NewReader(strings.Join(many, "\n")), "processFulltextDocument", 2, rf, opts)
+	if err == nil {
+		t.Fatalf("got nil, want error")
+	}
+}
+
+func TestJobOptionsApply(t *testing.T) {
+	var (
+		level    = 2
+		progress = func(sent, total int64) {}
+		base     = &Options{
+			ConsolidateHeader: true,
+			TEICoordinatesAll: true,
+			Timeout:           time.Minute,
+			TimeoutPerMB:      time.Second,
+			UploadProgress:    progress,
+			RetryOnEmptyBody:  true,
+			Verbose:           true,
+			StreamToDisk:      true,
+			OutputDir:         "out",
+		}
+		opts = (&JobOptions{ConsolidateHeader: &level}).apply(base)
+	)
+	if opts.ConsolidateHeaderLevel != 2 || opts.Timeout != time.Minute || opts.TimeoutPerMB != time.Second ||
+		opts.UploadProgress == nil || !opts.RetryOnEmptyBody || !opts.Verbose || !opts.StreamToDisk || opts.OutputDir != "out" {
+		t.Fatalf("got %+v, want batch options with job overrides", opts)
+	}
+	if !reflect.DeepEqual(opts.TEICoordinates, DefaultTEICoordinates) {
+		t.Fatalf("got %v, want %v", opts.TEICoordinates, DefaultTEICoordinates)
+	}
+	if base.ConsolidateHeaderLevel != 0 {
+		t.Fatalf("got %v, want batch options unchanged", base.ConsolidateHeaderLevel)
+	}
+}
+
+func TestProcessDirResults(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, name := range []string{"a.pdf", "b.pdf", "c/d.pdf"} {
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(dst, b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	results, err := grobid.ProcessDirResults(context.Background(), dir, "processFulltextDocument", 2, &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var filenames []string
+	for result := range results {
+		if !result.IsSuccess() {
+			t.Fatalf("got %v, want success", result)
+		}
+		filenames = append(filenames, result.Filename)
+	}
+	sort.Strings(filenames)
+	want := []string{filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf"), filepath.Join(dir, "c/d.pdf")}
+	if !reflect.DeepEqual(filenames, want) {
+		t.Fatalf("got %v, want %v", filenames, want)
+	}
+	// A cancelled context closes the channel early.
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err = grobid.ProcessDirResults(ctx, dir, "processFulltextDocument", 1, &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	<-results
+	cancel()
+	for range results {
+	}
+	if _, err := grobid.ProcessDirResults(context.Background(), filepath.Join(dir, "missing"), "processFulltextDocument", 1, &Options{}); err == nil {
+		t.Fatalf("got nil, want error for missing directory")
+	}
+}
+
+func TestProcessDirRecursivePreserveTree(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, fh, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "<TEI>%s</TEI>", fh.Filename)
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, name := range []string{"a/x.pdf", "b/x.pdf", "b/c/x.pdf"} {
+		dst := filepath.Join(dir, "in", name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(dst, b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about        string
+		preserveTree bool
+		result       []string
 	}{
-		{
-			about:  "nil",
-			result: nil,
-			opts:   nil,
-			dst:    "",
-			err:    nil,
-		},
-		{
-			about:  "empty result",
-			result: &Result{},
-			opts:   nil,
-			dst:    "",
-			err:    nil,
-		},
-		{
-			about: "only 200",
-			result: &Result{
-				StatusCode: 200,
-			},
-			opts: nil,
-			dst:  "_200.txt",
-			err:  nil,
-		},
-		{
-			about: "only 200, zero body",
-			result: &Result{
-				Filename:   "zerobody.jpg",
-				StatusCode: 200,
-			},
-			opts: nil,
-			dst:  "zerobody_200.txt",
-			err:  nil,
-		},
-		{
-			about: "only 200, 1 byte body",
-			result: &Result{
-				Filename:   "1byte.txt",
-				StatusCode: 200,
-				Body:       []byte{'1'},
-			},
-			opts: nil,
-			dst:  "1byte.grobid.tei.xml",
-			err:  nil,
-		},
+		{"flat", false, []string{"x.grobid.tei.xml"}},
+		{"tree", true, []string{"a/x.grobid.tei.xml", "b/c/x.grobid.tei.xml", "b/x.grobid.tei.xml"}},
 	}
 	for _, c := range cases {
-		err := DefaultResultWriter(c.result, c.opts)
-		if err != c.err {
-			t.Fatalf("got %v, want %v", err, c.err)
+		outputDir := filepath.Join(dir, "out-"+c.about)
+		opts := &Options{OutputDir: outputDir, PreserveTree: c.preserveTree}
+		err := grobid.ProcessDirRecursive(filepath.Join(dir, "in"), "processFulltextDocument", 2, DefaultResultWriter, opts)
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
 		}
-		if c.dst != "" {
-			if _, err := os.Stat(c.dst); os.IsNotExist(err) {
-				t.Errorf("expected file %v as side effect", c.dst)
+		var result []string
+		err = filepath.Walk(outputDir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(outputDir, path)
+			result = append(result, filepath.ToSlash(rel))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("[%s] walk: %v", c.about, err)
+		}
+		if !reflect.DeepEqual(result, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+	// Options reused for another directory use that directory as root.
+	outputDir := filepath.Join(dir, "out-reuse")
+	opts := &Options{OutputDir: outputDir, PreserveTree: true}
+	for _, sub := range []string{"a", "b"} {
+		err := grobid.ProcessDirRecursive(filepath.Join(dir, "in", sub), "processFulltextDocument", 2, DefaultResultWriter, opts)
+		if err != nil {
+			t.Fatalf("[reuse] got %v, want nil", err)
+		}
+	}
+	if opts.Root != "" {
+		t.Fatalf("[reuse] got root %q, want options unchanged", opts.Root)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "c", "x.grobid.tei.xml")); err != nil {
+		t.Fatalf("[reuse] got %v, want mirrored output", err)
+	}
+	// Inputs outside of the root are written to the output directory.
+	opts = &Options{OutputDir: "out", PreserveTree: true, Root: "in"}
+	if got, want := outputFilename("elsewhere/y.pdf", opts), "out/y.grobid.tei.xml"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestProcessDirRecursiveSkipLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	for _, name := range []string{"a.grobid.tei.xml", "b.grobid.tei.xml", "c.txt", "d.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var cases = []struct {
+		about   string
+		verbose bool
+		want    int
+	}{
+		{"once per extension", false, 2},
+		{"verbose", true, 4},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		grobid := &Grobid{Server: ts.URL, Client: ts.Client(), Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+		opts := &Options{Verbose: c.verbose}
+		if err := grobid.ProcessDirRecursive(dir, "processFulltextDocument", 1, DebugResultWriter, opts); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if n := strings.Count(buf.String(), "requires another service"); n != c.want {
+			t.Fatalf("[%s] got %d log lines, want %d: %s", c.about, n, c.want, buf.String())
+		}
+	}
+}
+
+func TestProcessArchives(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		_, fh, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "<TEI>%s</TEI>", fh.Filename)
+	}))
+	defer ts.Close()
+	pdf, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var (
+		dir     = t.TempDir()
+		entries = []struct {
+			name string
+			body []byte
+		}{
+			{"a.pdf", pdf},
+			{"./sub/b.pdf", pdf},
+			{"notes.md", []byte("not a pdf")},
+			{"../evil.pdf", pdf},
+			// Types are detected from the entries, not from local files.
+			{"c", pdf},
+			{"fake.pdf", []byte("not a pdf")},
+			{"testdata/pdf/1906.11632.pdf", []byte("not a pdf")},
+		}
+		zipPath   = filepath.Join(dir, "in.zip")
+		tarPath   = filepath.Join(dir, "in.tar")
+		tarGzPath = filepath.Join(dir, "in.tar.gz")
+	)
+	var zbuf, tbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	tw := tar.NewWriter(&tbuf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		w.Write(e.body)
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body))}); err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		tw.Write(e.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar: %v", err)
+	}
+	var gzbuf bytes.Buffer
+	gw := gzip.NewWriter(&gzbuf)
+	gw.Write(tbuf.Bytes())
+	gw.Close()
+	for name, b := range map[string][]byte{zipPath: zbuf.Bytes(), tarPath: tbuf.Bytes(), tarGzPath: gzbuf.Bytes()} {
+		if err := os.WriteFile(name, b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about   string
+		process func(ctx context.Context, name, service string, numWorkers int, rf ResultFunc, opts *Options) error
+		path    string
+	}{
+		{"zip", grobid.ProcessZip, zipPath},
+		{"tar", grobid.ProcessTar, tarPath},
+		{"tar.gz", grobid.ProcessTar, tarGzPath},
+	}
+	want := []string{"a.grobid.tei.xml", "c.grobid.tei.xml", "sub/b.grobid.tei.xml"}
+	for _, c := range cases {
+		numRequests.Store(0)
+		outputDir := filepath.Join(dir, "out-"+c.about)
+		for i := 0; i < 2; i++ {
+			opts := &Options{OutputDir: outputDir, PreserveTree: true}
+			err := c.process(context.Background(), c.path, "processFulltextDocument", 2, DefaultResultWriter, opts)
+			if err != nil {
+				t.Fatalf("[%s] got %v, want nil", c.about, err)
+			}
+		}
+		// The second run skips the entries processed already.
+		if n := numRequests.Load(); n != 3 {
+			t.Fatalf("[%s] got %d requests, want 3", c.about, n)
+		}
+		var result []string
+		err := filepath.Walk(outputDir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(outputDir, path)
+			result = append(result, filepath.ToSlash(rel))
+			return err
+		})
+		if err != nil {
+			t.Fatalf("[%s] walk: %v", c.about, err)
+		}
+		if !reflect.DeepEqual(result, want) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.grobid.tei.xml")); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want entry outside of archive skipped", err)
+	}
+}
+
+func TestProcessPathsWalkError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var paths []string
+	for i := 0; i < 3; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.pdf", i))
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		paths = append(paths, p)
+	}
+	var (
+		grobid  = &Grobid{Server: ts.URL, Client: ts.Client()}
+		errWalk = errors.New("walk failed")
+		// walk enqueues some files, then fails.
+		walk = func(enqueue func(string) error) error {
+			for _, p := range paths {
+				if err := enqueue(p); err != nil {
+					return err
+				}
+			}
+			return errWalk
+		}
+	)
+	var cases = []struct {
+		about          string
+		walkBufferSize int
+	}{
+		{"unbuffered", 0},
+		{"buffered", 2},
+		{"buffer larger than walk", 16},
+	}
+	for _, c := range cases {
+		var (
+			mu        sync.Mutex
+			filenames []string
+			rf        = func(result *Result, _ *Options) error {
+				mu.Lock()
+				defer mu.Unlock()
+				filenames = append(filenames, result.Filename)
+				return nil
+			}
+			opts = &Options{WalkBufferSize: c.walkBufferSize}
+			errC = make(chan error, 1)
+		)
+		go func() {
+			errC <- grobid.processPaths(context.Background(), "processFulltextDocument", 1, rf, opts, walk)
+		}()
+		select {
+		case err := <-errC:
+			if !errors.Is(err, errWalk) {
+				t.Fatalf("[%s] got %v, want %v", c.about, err, errWalk)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%s] processing did not finish", c.about)
+		}
+		sort.Strings(filenames)
+		if !reflect.DeepEqual(filenames, paths) {
+			t.Fatalf("[%s] got %v, want %v", c.about, filenames, paths)
+		}
+	}
+}
+
+func TestProcessDirRecursiveFailFast(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%02d.pdf", i)), b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	errFailed := errors.New("failed")
+	rf := func(result *Result, opts *Options) error {
+		if !result.IsSuccess() {
+			return errFailed
+		}
+		return nil
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about        string
+		failFast     bool
+		wantRequests int64
+	}{
+		{"aggregate", false, 20},
+		{"fail fast", true, 1},
+	}
+	for _, c := range cases {
+		numRequests.Store(0)
+		opts := &Options{FailFast: c.failFast}
+		err := grobid.ProcessDirRecursive(dir, "processFulltextDocument", 1, rf, opts)
+		if !errors.Is(err, errFailed) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, errFailed)
+		}
+		if got := numRequests.Load(); got != c.wantRequests {
+			t.Fatalf("[%s] got %d requests, want %d", c.about, got, c.wantRequests)
+		}
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Put("a", []byte("1"))
+	cache.Put("b", []byte("2"))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("got miss, want hit for a")
+	}
+	cache.Put("c", []byte("3")) // evicts b, as a was used more recently
+	var cases = []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"a", "1", true},
+		{"b", "", false},
+		{"c", "3", true},
+	}
+	for _, c := range cases {
+		v, ok := cache.Get(c.key)
+		if ok != c.ok || string(v) != c.value {
+			t.Fatalf("[%s] got %q, %v, want %q, %v", c.key, v, ok, c.value, c.ok)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Fatalf("got %d, want 2", cache.Len())
+	}
+}
+
+func TestProcessPDFCache(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client(), Cache: NewLRUCache(8)}
+	for _, name := range []string{"a.pdf", "b.pdf", "a.pdf"} {
+		filename := filepath.Join(dir, name)
+		result, err := grobid.ProcessPDFContext(context.Background(), filename, "processFulltextDocument", &Options{})
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if !result.IsSuccess() || result.StringBody() != "<TEI/>" || result.Filename != filename {
+			t.Fatalf("got %v, want success", result)
+		}
+		if want := fmt.Sprintf("%x", sha1.Sum(b)); result.SHA1Hex != want {
+			t.Fatalf("got %v, want %v", result.SHA1Hex, want)
+		}
+	}
+	if got := numRequests.Load(); got != 1 {
+		t.Fatalf("got %d requests, want 1", got)
+	}
+	// Other services and options do not use the cached response.
+	var cases = []struct {
+		about       string
+		service     string
+		opts        *Options
+		numRequests int64
+	}{
+		{"same service and options", "processFulltextDocument", &Options{}, 1},
+		{"other service", "processHeaderDocument", &Options{}, 2},
+		{"other options", "processFulltextDocument", &Options{ConsolidateHeaderLevel: 1}, 3},
+		{"other accept header", "processFulltextDocument", &Options{AcceptHeader: "application/x-bibtex"}, 4},
+		{"other service, cached", "processHeaderDocument", &Options{}, 4},
+	}
+	for _, c := range cases {
+		if _, err := grobid.ProcessPDFContext(context.Background(), filepath.Join(dir, "a.pdf"), c.service, c.opts); err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if got := numRequests.Load(); got != c.numRequests {
+			t.Fatalf("[%s] got %d requests, want %d", c.about, got, c.numRequests)
+		}
+	}
+	// Batches use the cache as well.
+	numRequests.Store(0)
+	grobid.Cache = NewLRUCache(8)
+	rf := func(r *Result, opts *Options) error {
+		if !r.IsSuccess() || r.StringBody() != "<TEI/>" {
+			t.Errorf("got %v, want success", r)
+		}
+		return nil
+	}
+	if err := grobid.ProcessDirRecursive(dir, "processFulltextDocument", 1, rf, &Options{Force: true}); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if got := numRequests.Load(); got != 1 {
+		t.Fatalf("got %d requests, want 1", got)
+	}
+}
+
+func TestProcessPDFGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, fh, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		if !bytes.HasPrefix(b, []byte("%PDF")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "<TEI>%s</TEI>", fh.Filename)
+	}))
+	defer ts.Close()
+	b, err := os.ReadFile("testdata/pdf/062RoisinAronAmericanNaturalist03.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	filename := "testdata/gz/062RoisinAronAmericanNaturalist03.pdf.gz"
+	result, err := grobid.ProcessPDF(filename, "processFulltextDocument", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", result.StatusCode)
+	}
+	if want := "<TEI>062RoisinAronAmericanNaturalist03.pdf</TEI>"; result.StringBody() != want {
+		t.Fatalf("got %v, want %v", result.StringBody(), want)
+	}
+	if want := fmt.Sprintf("%x", sha1.Sum(b)); result.SHA1Hex != want {
+		t.Fatalf("got %v, want %v", result.SHA1Hex, want)
+	}
+	if !matchesService("processFulltextDocument", filename) {
+		t.Fatalf("expected gzipped PDF to be accepted by walker")
+	}
+	if want := "testdata/gz/062RoisinAronAmericanNaturalist03.grobid.tei.xml"; outputFilename(filename, &Options{}) != want {
+		t.Fatalf("got %v, want %v", outputFilename(filename, &Options{}), want)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var cases = []struct {
+		about   string
+		alive   int
+		version string
+		result  *HealthStatus
+	}{
+		{
+			about:   "json version",
+			alive:   200,
+			version: `{"version":"0.8.1","revision":"aa8f5b0"}`,
+			result:  &HealthStatus{Alive: true, Version: "0.8.1"},
+		},
+		{
+			about:   "plain version",
+			alive:   200,
+			version: "0.5.5\n",
+			result:  &HealthStatus{Alive: true, Version: "0.5.5"},
+		},
+		{
+			about:   "not alive",
+			alive:   503,
+			version: "",
+			result:  &HealthStatus{Alive: false},
+		},
+	}
+	for _, c := range cases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/isalive":
+				w.WriteHeader(c.alive)
+				io.WriteString(w, "true")
+			case "/api/version":
+				io.WriteString(w, c.version)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+		hs, err := grobid.Health(context.Background())
+		ts.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if hs.Alive != c.result.Alive || hs.Version != c.result.Version {
+			t.Fatalf("[%s] got %v, want %v", c.about, hs, c.result)
+		}
+		if hs.Alive != (hs.Err == nil) {
+			t.Fatalf("[%s] got err %v, alive %v", c.about, hs.Err, hs.Alive)
+		}
+		if hs.Latency <= 0 {
+			t.Fatalf("[%s] expected latency to be set", c.about)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	var (
+		numRequests atomic.Int64
+		readyAfter  atomic.Int64
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/isalive" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if n := numRequests.Add(1); n <= readyAfter.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "true")
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client(), Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	var cases = []struct {
+		about        string
+		readyAfter   int64
+		timeout      time.Duration
+		err          error
+		wantRequests int64
+	}{
+		{"ready", 0, time.Second, nil, 1},
+		{"ready after some attempts", 3, 5 * time.Second, nil, 4},
+		{"never ready", 1 << 20, 50 * time.Millisecond, context.DeadlineExceeded, -1},
+	}
+	for _, c := range cases {
+		numRequests.Store(0)
+		readyAfter.Store(c.readyAfter)
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		err := grobid.WaitReady(ctx, time.Millisecond)
+		cancel()
+		if !errors.Is(err, c.err) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if got := numRequests.Load(); c.wantRequests >= 0 && got != c.wantRequests {
+			t.Fatalf("[%s] got %d requests, want %d", c.about, got, c.wantRequests)
+		}
+	}
+}
+
+func TestRecommendedWorkers(t *testing.T) {
+	var cases = []struct {
+		concurrency int
+		workers     int
+	}{
+		{0, 1},
+		{1, 1},
+		{10, 15},
+		{16, 24},
+	}
+	for _, c := range cases {
+		if got := RecommendedWorkers(c.concurrency); got != c.workers {
+			t.Fatalf("[%d] got %v, want %v", c.concurrency, got, c.workers)
+		}
+	}
+}
+
+func TestProcessTextBatches(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		var payload struct {
+			Citations []string `json:"citations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `<TEI xmlns="http://www.tei-c.org/ns/1.0"><text><back><div><listBibl>`)
+		for _, c := range payload.Citations {
+			fmt.Fprintf(w, `<biblStruct><note type="raw_reference">%s</note></biblStruct>`, c)
+		}
+		io.WriteString(w, `</listBibl></div></back></text></TEI>`)
+	}))
+	defer ts.Close()
+	f, err := os.CreateTemp(t.TempDir(), "refs-*.txt")
+	if err != nil {
+		t.Fatalf("temp: %v", err)
+	}
+	var want []string
+	for i := 0; i < 7; i++ {
+		want = append(want, fmt.Sprintf("ref %d", i))
+		fmt.Fprintln(f, want[i])
+	}
+	f.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	opts := &Options{CitationBatchSize: 3, CitationBatchWorkers: 2}
+	result, err := grobid.ProcessText(f.Name(), "processCitationList", opts)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != 200 {
+		t.Fatalf("got %v, want 200", result.StatusCode)
+	}
+	if numRequests.Load() != 3 {
+		t.Fatalf("got %v requests, want 3", numRequests.Load())
+	}
+	citations := tei.ParseCitationList(result.StringBody())
+	if len(citations) != len(want) {
+		t.Fatalf("got %v citations, want %v", len(citations), len(want))
+	}
+	for i, c := range citations {
+		if c.Index != i || c.Unstructured != want[i] {
+			t.Fatalf("got %v %v, want %v %v", c.Index, c.Unstructured, i, want[i])
+		}
+	}
+}
+
+func TestProcessTextContext(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	filename := filepath.Join(t.TempDir(), "refs.txt")
+	if err := os.WriteFile(filename, []byte("ref 0\nref 1\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about string
+		opts  *Options
+	}{
+		{"single request", &Options{}},
+		{"batched", &Options{CitationBatchSize: 1}},
+	}
+	for _, c := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := grobid.ProcessTextContext(ctx, filename, "processCitationList", c.opts)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, context.DeadlineExceeded)
+		}
+	}
+}
+
+func TestProcessTextCompressRequest(t *testing.T) {
+	var large strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&large, "Doe J. A rather long title of reference number %d. Journal 2019;23:235-243.\n", i)
+	}
+	dir := t.TempDir()
+	var (
+		largeFile = filepath.Join(dir, "large.txt")
+		smallFile = filepath.Join(dir, "small.txt")
+	)
+	if err := os.WriteFile(largeFile, []byte(large.String()), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(smallFile, []byte("ref 0\nref 1\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var cases = []struct {
+		about      string
+		filename   string
+		rejectGzip bool
+		encodings  []string
+	}{
+		{"compressed", largeFile, false, []string{"gzip"}},
+		{"rejected", largeFile, true, []string{"gzip", ""}},
+		{"small", smallFile, false, []string{""}},
+	}
+	for _, c := range cases {
+		var (
+			mu        sync.Mutex
+			encodings []string
+		)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := r.Header.Get("Content-Encoding")
+			mu.Lock()
+			encodings = append(encodings, encoding)
+			mu.Unlock()
+			if encoding == "gzip" && c.rejectGzip {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			var body io.Reader = r.Body
+			if encoding == "gzip" {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				body = zr
+			}
+			var payload struct {
+				Citations []string `json:"citations"`
+			}
+			if err := json.NewDecoder(body).Decode(&payload); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, "<TEI>%d</TEI>", len(payload.Citations))
+		}))
+		grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+		result, err := grobid.ProcessText(c.filename, "processCitationList", &Options{CompressRequest: true})
+		ts.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !result.IsSuccess() {
+			t.Fatalf("[%s] got %d, want success", c.about, result.StatusCode)
+		}
+		if !reflect.DeepEqual(encodings, c.encodings) {
+			t.Fatalf("[%s] got %q, want %q", c.about, encodings, c.encodings)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var cases = []struct {
+		about  string
+		opts   *Options
+		size   int64
+		result time.Duration
+	}{
+		{"no timeout", &Options{}, 1 << 20, 0},
+		{"base only", &Options{Timeout: time.Minute}, 1 << 20, time.Minute},
+		{"scaled only", &Options{TimeoutPerMB: 10 * time.Second}, 3 << 20, 30 * time.Second},
+		{"base and scaled", &Options{Timeout: time.Minute, TimeoutPerMB: 10 * time.Second}, 1 << 19, 65 * time.Second},
+		{"client timeout", &Options{Timeout: time.Second, TimeoutPerMB: time.Second}, 1 << 20, 30 * time.Second},
+		{"client timeout only", &Options{}, 1 << 20, 0},
+	}
+	for _, c := range cases {
+		if got := c.opts.requestTimeout(c.size, 30*time.Second); got != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.result)
+		}
+	}
+}
+
+func TestProcessPDFUploadProgress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	filename := "testdata/pdf/1906.11632.pdf"
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	var (
+		mu    sync.Mutex
+		calls [][2]int64
+	)
+	opts := &Options{
+		UploadProgress: func(sent, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, [2]int64{sent, total})
+		},
+	}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	result, err := grobid.ProcessPDFContext(context.Background(), filename, "processFulltextDocument", opts)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if !result.IsSuccess() {
+		t.Fatalf("got %d, want success", result.StatusCode)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) == 0 {
+		t.Fatalf("got no progress calls")
+	}
+	for i, c := range calls {
+		if c[1] != fi.Size() || (i > 0 && c[0] <= calls[i-1][0]) {
+			t.Fatalf("got %v, want increasing sent bytes of %d total", calls, fi.Size())
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != fi.Size() {
+		t.Fatalf("got %d bytes sent, want %d", last[0], fi.Size())
+	}
+}
+
+func TestProcessPDFRetryOnEmptyBody(t *testing.T) {
+	defer func(d time.Duration) { emptyBodyWait = d }(emptyBodyWait)
+	emptyBodyWait = time.Millisecond
+	filename := "testdata/pdf/1906.11632.pdf"
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	var cases = []struct {
+		about       string
+		numEmpty    int
+		retry       bool
+		err         error
+		numRequests int
+		retryCount  int
+	}{
+		{about: "no retry", numEmpty: 1, retry: false, err: nil, numRequests: 1},
+		{about: "recovers", numEmpty: 2, retry: true, err: nil, numRequests: 3, retryCount: 2},
+		{about: "gives up", numEmpty: 5, retry: true, err: ErrEmptyBody, numRequests: 3, retryCount: 2},
+	}
+	for _, c := range cases {
+		var numRequests int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			numRequests++
+			f, _, err := r.FormFile("input")
+			if err != nil {
+				t.Errorf("[%s] form file: %v", c.about, err)
+				return
+			}
+			if n, _ := io.Copy(io.Discard, f); n != fi.Size() {
+				t.Errorf("[%s] got %d bytes, want %d", c.about, n, fi.Size())
+			}
+			if numRequests > c.numEmpty {
+				io.WriteString(w, "<TEI/>")
+			}
+		}))
+		grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+		opts := &Options{RetryOnEmptyBody: c.retry}
+		result, err := grobid.ProcessPDFContext(context.Background(), filename, "processFulltextDocument", opts)
+		ts.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !errors.Is(result.Err, c.err) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result.Err, c.err)
+		}
+		if numRequests != c.numRequests {
+			t.Fatalf("[%s] got %d requests, want %d", c.about, numRequests, c.numRequests)
+		}
+		if result.RetryCount != c.retryCount {
+			t.Fatalf("[%s] got retry count %d, want %d", c.about, result.RetryCount, c.retryCount)
+		}
+		if c.err != nil && !result.IsRetryable() {
+			t.Fatalf("[%s] got not retryable, want retryable", c.about)
+		}
+	}
+}
+
+func TestProcessPDFTimeoutPerMB(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(200 * time.Millisecond):
+			io.WriteString(w, "<TEI/>")
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	// withoutTimeout is set up like grobidcli with -timeout-per-mb, with
+	// the limit enforced per file instead of per attempt.
+	withoutTimeout := pester.NewExtendedClient(&http.Client{Transport: ts.Client().Transport})
+	withoutTimeout.MaxRetries = 1
+	var cases = []struct {
+		about  string
+		client Doer
+		opts   *Options
+		err    bool
+	}{
+		{"base timeout too short", ts.Client(), &Options{Timeout: 20 * time.Millisecond}, true},
+		{"scaled by size", ts.Client(), &Options{Timeout: 20 * time.Millisecond, TimeoutPerMB: time.Hour}, false},
+		{"pester, base timeout too short", withoutTimeout, &Options{Timeout: 20 * time.Millisecond}, true},
+		{"pester, scaled by size", withoutTimeout, &Options{Timeout: 20 * time.Millisecond, TimeoutPerMB: time.Hour}, false},
+	}
+	for _, c := range cases {
+		grobid := &Grobid{Server: ts.URL, Client: c.client}
+		result, err := grobid.ProcessPDFContext(context.Background(), "testdata/pdf/1906.11632.pdf", "processFulltextDocument", c.opts)
+		if err == nil && !result.IsSuccess() {
+			err = result.Err
+		}
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	var cases = []struct {
+		about  string
+		client Doer
+		result time.Duration
+	}{
+		{"http client", &http.Client{Timeout: time.Minute}, time.Minute},
+		{"pester client", pester.NewExtendedClient(&http.Client{Timeout: time.Minute}), time.Minute},
+		{"default client", New("http://localhost:8070").Client, 60 * time.Second},
+		{"unknown client", NewTestClient(nil).Client, 0},
+	}
+	for _, c := range cases {
+		g := &Grobid{Client: c.client}
+		if got := g.clientTimeout(); got != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.result)
+		}
+	}
+}
+
+func TestProcessReadersRetryOnEmptyBody(t *testing.T) {
+	defer func(d time.Duration) { emptyBodyWait = d }(emptyBodyWait)
+	emptyBodyWait = time.Millisecond
+	var (
+		mu          sync.Mutex
+		numRequests = make(map[string]int) // by input name
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, h, err := r.FormFile("input")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if n, _ := io.Copy(io.Discard, f); n != 4 {
+			t.Errorf("got %d bytes, want 4", n)
+		}
+		mu.Lock()
+		numRequests[h.Filename]++
+		n := numRequests[h.Filename]
+		mu.Unlock()
+		if n > 1 {
+			io.WriteString(w, "<TEI/>")
+		}
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs := make(chan NamedReader, 3)
+	inputs <- NamedReader{Name: filepath.Join(dir, "a.pdf"), Reader: &lazyFile{name: filepath.Join(dir, "a.pdf")}}
+	inputs <- NamedReader{Name: "b.pdf", Reader: bytes.NewReader([]byte("%PDF"))}
+	inputs <- NamedReader{Name: "c.pdf", Reader: io.MultiReader(strings.NewReader("%PDF"))}
+	close(inputs)
+	var (
+		results = make(map[string]*Result)
+		rf      = func(r *Result, opts *Options) error {
+			mu.Lock()
+			defer mu.Unlock()
+			results[filepath.Base(r.Filename)] = r
+			return nil
+		}
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+	)
+	if err := grobid.ProcessReaders(context.Background(), inputs, "processFulltextDocument", 1, rf, &Options{RetryOnEmptyBody: true}); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var cases = []struct {
+		about       string
+		name        string
+		numRequests int
+		retryCount  int
+	}{
+		{"file", "a.pdf", 2, 1},
+		{"archive entry", "b.pdf", 2, 1},
+		{"reader, that cannot be sent again", "c.pdf", 1, 0},
+	}
+	for _, c := range cases {
+		r, ok := results[c.name]
+		if !ok {
+			t.Fatalf("[%s] got no result", c.about)
+		}
+		if numRequests[c.name] != c.numRequests {
+			t.Fatalf("[%s] got %d requests, want %d", c.about, numRequests[c.name], c.numRequests)
+		}
+		if r.RetryCount != c.retryCount {
+			t.Fatalf("[%s] got retry count %d, want %d", c.about, r.RetryCount, c.retryCount)
+		}
+	}
+}
+
+func TestProcessTextJSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<biblStruct><note type="raw_reference">xml</note></biblStruct>`)
+			return
+		}
+		var payload struct {
+			Citations []string `json:"citations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var citations []map[string]string
+		for _, c := range payload.Citations {
+			if c == "ref 3" {
+				// Unparsable citations may come back as null.
+				citations = append(citations, nil)
+				continue
+			}
+			citations = append(citations, map[string]string{"unstructured": c})
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(citations)
+	}))
+	defer ts.Close()
+	f, err := os.CreateTemp(t.TempDir(), "refs-*.txt")
+	if err != nil {
+		t.Fatalf("temp: %v", err)
+	}
+	fmt.Fprintln(f, "ref 0\nref 1\nref 2\nref 3\nref 4")
+	f.Close()
+	want := []string{"ref 0", "ref 1", "ref 2", "null", "ref 4"}
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about string
+		opts  *Options
+		want  []string
+	}{
+		{"xml", &Options{}, []string{"xml"}},
+		{"json", &Options{AcceptHeader: "application/json"}, want},
+		{"json, batched", &Options{AcceptHeader: "application/json", CitationBatchSize: 2}, want},
+	}
+	for _, c := range cases {
+		result, err := grobid.ProcessText(f.Name(), "processCitationList", c.opts)
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		citations, err := result.Citations()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		var got []string
+		for i, c := range citations {
+			if c == nil {
+				got = append(got, "null")
+				continue
+			}
+			if c.Index != i {
+				t.Fatalf("got index %v, want %v", c.Index, i)
+			}
+			got = append(got, c.Unstructured)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.want)
+		}
+	}
+}
+
+func TestServiceForFile(t *testing.T) {
+	var cases = []struct {
+		path    string
+		service string
+		ok      bool
+	}{
+		{"testdata/pdf/1906.11632.pdf", "processFulltextDocument", true},
+		{"testdata/gz/062RoisinAronAmericanNaturalist03.pdf.gz", "processFulltextDocument", true},
+		{"refs.txt", "processCitationList", true},
+		{"testdata/small.xml", "processCitationPatentST36", true},
+		{"testdata/small.json", "", false},
+		{"image.PNG", "", false},
+	}
+	for _, c := range cases {
+		service, ok := ServiceForFile(c.path)
+		if service != c.service || ok != c.ok {
+			t.Fatalf("[%s] got %v %v, want %v %v", c.path, service, ok, c.service, c.ok)
+		}
+	}
+}
+
+func TestIsOversize(t *testing.T) {
+	var cases = []struct {
+		about      string
+		statusCode int
+		body       string
+		result     bool
+	}{
+		{"ok", 200, "too large, but fine", false},
+		{"413", 413, "", true},
+		{"too many tokens", 500, "[TOO_MANY_TOKENS] The document has too many tokens", true},
+		{"too many blocks", 500, "[TOO_MANY_BLOCKS] Too many blocks in the document", true},
+		{"500 without message", 500, "NullPointerException", false},
+		{"500 mentioning a size", 500, "[GENERAL] cache size limit reached, file size unknown", false},
+		{"400 mentioning a size", 400, "Request header too large", false},
+		{"too many tokens with another status", 400, "[TOO_MANY_TOKENS]", false},
+		{"503", 503, "", false},
+	}
+	for _, c := range cases {
+		result := isOversize(c.statusCode, []byte(c.body))
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	var cases = []struct {
+		about  string
+		r      io.Reader
+		result []string
+		err    error
+	}{
+		{
+			about:  "nothing to read",
+			r:      strings.NewReader(``),
+			result: nil,
+			err:    nil,
+		},
+		{
+			about:  "single line",
+			r:      strings.NewReader("1\n"),
+			result: []string{"1"},
+			err:    nil,
+		},
+		{
+			about:  "just an empty line",
+			r:      strings.NewReader("\n"),
+			result: nil,
+			err:    nil,
+		},
+		{
+			about:  "just an empty line",
+			r:      strings.NewReader("1\n2\n3  \n"),
+			result: []string{"1", "2", "3"},
+			err:    nil,
+		},
+	}
+	for _, c := range cases {
+		lines, err := parseLines(c.r)
+		if err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+		if !reflect.DeepEqual(lines, c.result) {
+			t.Fatalf("[%s] got %v (%d), want %v (%d)", c.about, lines, len(lines), c.result, len(c.result))
+		}
+	}
+}
+
+func TestDefaultResultWriter(t *testing.T) {
+	var cases = []struct {
+		about  string
+		result *Result
+		opts   *Options
+		dst    string // destination file
+		err    error
+	}{
+		{
+			about:  "nil",
+			result: nil,
+			opts:   nil,
+			dst:    "",
+			err:    nil,
+		},
+		{
+			about:  "empty result",
+			result: &Result{},
+			opts:   nil,
+			dst:    "",
+			err:    nil,
+		},
+		{
+			about: "only 200",
+			result: &Result{
+				StatusCode: 200,
+			},
+			opts: nil,
+			dst:  "_200.txt",
+			err:  nil,
+		},
+		{
+			about: "only 200, zero body",
+			result: &Result{
+				Filename:   "zerobody.jpg",
+				StatusCode: 200,
+			},
+			opts: nil,
+			dst:  "zerobody_200.txt",
+			err:  nil,
+		},
+		{
+			about: "only 200, 1 byte body",
+			result: &Result{
+				Filename:   "1byte.txt",
+				StatusCode: 200,
+				Body:       []byte{'1'},
+			},
+			opts: nil,
+			dst:  "1byte.grobid.tei.xml",
+			err:  nil,
+		},
+	}
+	for _, c := range cases {
+		err := DefaultResultWriter(c.result, c.opts)
+		if err != c.err {
+			t.Fatalf("got %v, want %v", err, c.err)
+		}
+		if c.dst != "" {
+			if c.result.OutputPath != c.dst {
+				t.Errorf("got output path %v, want %v", c.result.OutputPath, c.dst)
+			}
+			if _, err := os.Stat(c.dst); os.IsNotExist(err) {
+				t.Errorf("expected file %v as side effect", c.dst)
 			}
 			// TODO: rework result writer interface, so it a bit less awkward to test
 			if _, err := os.Stat(c.dst); err == nil {
@@ -177,6 +1970,979 @@ func TestDefaultResultWriter(t *testing.T) {
 	}
 }
 
+func TestErrorStubPrefix(t *testing.T) {
+	var cases = []struct {
+		about  string
+		path   string
+		prefix string
+		ok     bool
+	}{
+		{
+			about:  "empty",
+			path:   "",
+			prefix: "",
+			ok:     false,
+		},
+		{
+			about:  "tei file",
+			path:   "a/b.grobid.tei.xml",
+			prefix: "",
+			ok:     false,
+		},
+		{
+			about:  "plain text file",
+			path:   "a/refs.txt",
+			prefix: "",
+			ok:     false,
+		},
+		{
+			about:  "not a status code",
+			path:   "a/refs_2019.txt",
+			prefix: "",
+			ok:     false,
+		},
+		{
+			about:  "503 stub",
+			path:   "a/b_503.txt",
+			prefix: "a/b",
+			ok:     true,
+		},
+		{
+			about:  "pseudo-result stub",
+			path:   "a/b_c_-1.txt",
+			prefix: "a/b_c",
+			ok:     true,
+		},
+	}
+	for _, c := range cases {
+		prefix, ok := errorStubPrefix(c.path)
+		if prefix != c.prefix || ok != c.ok {
+			t.Fatalf("[%s] got %v %v, want %v %v", c.about, prefix, ok, c.prefix, c.ok)
+		}
+	}
+}
+
+func TestWriteFieldsConsolidation(t *testing.T) {
+	var cases = []struct {
+		about     string
+		opts      *Options
+		header    []string
+		citations []string
+	}{
+		{"off", &Options{}, nil, nil},
+		{"booleans", &Options{ConsolidateHeader: true, ConsolidateCitations: true}, []string{"1"}, []string{"1"}},
+		{"levels", &Options{ConsolidateHeaderLevel: 1, ConsolidateCitationsLevel: 2}, []string{"1"}, []string{"2"}},
+		{"level wins", &Options{ConsolidateCitations: true, ConsolidateCitationsLevel: 2}, nil, []string{"2"}},
+	}
+	for _, c := range cases {
+		values := formValues(t, c.opts)
+		if !reflect.DeepEqual(values["consolidateHeader"], c.header) {
+			t.Fatalf("[%s] got %v, want %v", c.about, values["consolidateHeader"], c.header)
+		}
+		if !reflect.DeepEqual(values["consolidateCitations"], c.citations) {
+			t.Fatalf("[%s] got %v, want %v", c.about, values["consolidateCitations"], c.citations)
+		}
+	}
+}
+
+func TestWriteFieldsTEICoordinates(t *testing.T) {
+	var cases = []struct {
+		about  string
+		opts   *Options
+		result []string
+	}{
+		{
+			about:  "no coordinates",
+			opts:   &Options{},
+			result: nil,
+		},
+		{
+			about:  "explicit coordinates",
+			opts:   &Options{TEICoordinates: []string{"ref"}},
+			result: []string{"ref"},
+		},
+		{
+			about:  "all coordinates",
+			opts:   &Options{TEICoordinatesAll: true},
+			result: DefaultTEICoordinates,
+		},
+		{
+			about:  "explicit coordinates win",
+			opts:   &Options{TEICoordinates: []string{"figure"}, TEICoordinatesAll: true},
+			result: []string{"figure"},
+		},
+		{
+			about:  "duplicates are removed, order is kept",
+			opts:   &Options{TEICoordinates: []string{"ref", "figure", "ref", "s", "figure"}},
+			result: []string{"ref", "figure", "s"},
+		},
+	}
+	for _, c := range cases {
+		values := formValues(t, c.opts)
+		if !reflect.DeepEqual(values["teiCoordinates"], c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, values["teiCoordinates"], c.result)
+		}
+	}
+}
+
+func TestFormFields(t *testing.T) {
+	var cases = []struct {
+		about  string
+		opts   *Options
+		result string
+	}{
+		{"empty", &Options{}, ""},
+		{
+			"all",
+			&Options{
+				ConsolidateHeader:         true,
+				ConsolidateCitationsLevel: 2,
+				GenerateIDs:               true,
+				IncludeRawCitations:       true,
+				IncluseRawAffiliations:    true,
+				SegmentSentences:          true,
+				TEICoordinates:            []string{"ref", "s"},
+			},
+			"consolidateCitations=2&consolidateHeader=1&generateIDs=1&includeRawAffiliations=1&" +
+				"includeRawCitations=1&segmentSentences=1&teiCoordinates=ref&teiCoordinates=s",
+		},
+	}
+	for _, c := range cases {
+		fields := c.opts.FormFields()
+		if got := fields.Encode(); got != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.result)
+		}
+		// The fields sent in the multipart body are the same.
+		if values := formValues(t, c.opts); len(values) > 0 && !reflect.DeepEqual(map[string][]string(fields), values) {
+			t.Fatalf("[%s] got %v, want %v", c.about, values, fields)
+		}
+	}
+}
+
+// formValues returns the form fields written by writeFields.
+func formValues(t *testing.T, opts *Options) map[string][]string {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	opts.writeFields(mw)
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	form, err := multipart.NewReader(&buf, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("read form: %v", err)
+	}
+	return form.Value
+}
+
+func TestDefaultResultWriterJSON(t *testing.T) {
+	small, err := os.ReadFile("testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	dir := t.TempDir()
+	opts := &Options{OutputDir: dir, WriteJSON: true}
+	for _, r := range []*Result{
+		{Filename: "a.pdf", StatusCode: 200, Body: small},
+		{Filename: "b.pdf", StatusCode: 200, Body: []byte("<TEI><teiHeader>")},
+	} {
+		if err := DefaultResultWriter(r, opts); err != nil {
+			t.Fatalf("[%s] got %v, want nil", r.Filename, err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "a.grobid.json"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var doc tei.GrobidDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("got %v, want valid JSON", err)
+	}
+	if doc.Header == nil || doc.Header.Title == "" {
+		t.Fatalf("got %s, want header with title", b)
+	}
+	for _, name := range []string{"a.grobid.tei.xml", "b.grobid.tei.xml", "b.grobid.json.err"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("got %v, want %s", err, name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.grobid.json")); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want no JSON for unparsable TEI", err)
+	}
+	// The JSON file does not count as output.
+	if err := os.Remove(filepath.Join(dir, "a.grobid.tei.xml")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if (&Grobid{}).isAlreadyProcessed("a.pdf", opts) {
+		t.Fatalf("got already processed, want not processed without TEI file")
+	}
+}
+
+func TestIsAlreadyProcessedVerify(t *testing.T) {
+	dir := t.TempDir()
+	var cases = []struct {
+		about    string
+		content  string // output file content, no file if empty
+		plain    bool   // result without verification
+		verified bool   // result with verification
+	}{
+		{"complete", `<?xml version="1.0"?>` + "\n" + `<TEI xmlns="http://www.tei-c.org/ns/1.0"><text>a</text></TEI>` + "\n", true, true},
+		{"self closing", "<TEI/>", true, true},
+		{"truncated", `<TEI xmlns="http://www.tei-c.org/ns/1.0"><teiHeader><fileDesc>`, true, false},
+		{"whitespace only", " \n", true, false},
+		{"other root", "<html><body>502 Bad Gateway</body></html>", true, false},
+		{"missing", "", false, false},
+	}
+	grobid := &Grobid{}
+	for i, c := range cases {
+		input := fmt.Sprintf("%d.pdf", i)
+		if c.content != "" {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.grobid.tei.xml", i)), []byte(c.content), 0644); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		if got := grobid.isAlreadyProcessed(input, &Options{OutputDir: dir}); got != c.plain {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.plain)
+		}
+		if got := grobid.isAlreadyProcessed(input, &Options{OutputDir: dir, VerifyProcessed: true}); got != c.verified {
+			t.Fatalf("[%s] verified: got %v, want %v", c.about, got, c.verified)
+		}
+	}
+}
+
+func TestDefaultResultWriterSymlinkTwice(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{OutputDir: dir, CreateHashSymlinks: true}
+	for i := 0; i < 2; i++ {
+		result := &Result{
+			Filename:   "a.pdf",
+			SHA1Hex:    "8843d7f92416211de9ebb963ff4ce28125932878",
+			StatusCode: 200,
+			Body:       []byte("<TEI/>"),
+		}
+		if err := DefaultResultWriter(result, opts); err != nil {
+			t.Fatalf("[%d] got %v, want nil", i, err)
+		}
+	}
+	link := filepath.Join(dir, "8843d7f92416211de9ebb963ff4ce28125932878.grobid.tei.xml")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("readlink: %v", err)
+	}
+	if want := "a.grobid.tei.xml"; target != want {
+		t.Fatalf("got %v, want %v", target, want)
+	}
+	// A symlink pointing elsewhere gets replaced, a regular file is an error.
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.Symlink("b.grobid.tei.xml", link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := ensureSymlink("a.grobid.tei.xml", link); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if target, _ := os.Readlink(link); target != "a.grobid.tei.xml" {
+		t.Fatalf("got %v, want a.grobid.tei.xml", target)
+	}
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := ensureSymlink("a.grobid.tei.xml", regular); err == nil {
+		t.Fatalf("got nil, want error")
+	}
+}
+
+func TestDefaultResultWriterShardDepth(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{OutputDir: dir, CreateHashSymlinks: true, ShardDepth: 2}
+	result := &Result{
+		Filename:   "a.pdf",
+		SHA1Hex:    "8843d7f92416211de9ebb963ff4ce28125932878",
+		StatusCode: 200,
+		Body:       []byte("<TEI/>"),
+	}
+	if err := DefaultResultWriter(result, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	link := filepath.Join(dir, "88", "43", "8843d7f92416211de9ebb963ff4ce28125932878.grobid.tei.xml")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("readlink: %v", err)
+	}
+	// The output is sharded by the SHA1 of the input, like the symlink.
+	if want := "a.grobid.tei.xml"; target != want {
+		t.Fatalf("got %v, want %v", target, want)
+	}
+	b, err := os.ReadFile(link)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(b) != "<TEI/>" {
+		t.Fatalf("got %s, want <TEI/>", b)
+	}
+}
+
+func TestProcessDirShardDepth(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numRequests.Add(1)
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "<TEI/>")
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	b, err := os.ReadFile("testdata/pdf/1906.11632.pdf")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "in"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "in", "1906.11632.pdf"), b, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var (
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+		opts   = &Options{OutputDir: filepath.Join(dir, "out"), ShardDepth: 2}
+		want   = filepath.Join(dir, "out", "a9", "3e", "1906.11632.grobid.tei.xml")
+	)
+	for i := 0; i < 2; i++ {
+		err := grobid.ProcessDirRecursive(filepath.Join(dir, "in"), "processFulltextDocument", 1, DefaultResultWriter, opts)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if _, err := os.Stat(want); err != nil {
+			t.Fatalf("got %v, want output at %s", err, want)
+		}
+		// The second run finds the sharded output and skips the input.
+		if n := numRequests.Load(); n != 1 {
+			t.Fatalf("got %d requests, want 1", n)
+		}
+	}
+}
+
+func TestDefaultResultWriterNameFunc(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		OutputDir:            dir,
+		ErrorOnNameCollision: true,
+		NameFunc: func(r *Result) (string, error) {
+			doi := tei.ParseCitation(string(r.Body)).DOI
+			return strings.ReplaceAll(doi, "/", "_") + "." + DefaultExt, nil
+		},
+	}
+	body := []byte(`<biblStruct><analytic><idno type="DOI">10.1234/abc</idno></analytic></biblStruct>`)
+	result := &Result{Filename: "a.pdf", StatusCode: 200, Body: body}
+	if err := DefaultResultWriter(result, opts); err == nil {
+		t.Fatalf("got nil, want error without a name registry")
+	}
+	rf := NewDefaultResultWriter()
+	if err := rf(result, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := filepath.Join(dir, "10.1234_abc.grobid.tei.xml"); result.OutputPath != want {
+		t.Fatalf("got %v, want %v", result.OutputPath, want)
+	}
+	if _, err := os.Stat(result.OutputPath); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	other := &Result{Filename: "b.pdf", StatusCode: 200, Body: body}
+	if err := rf(other, opts); !errors.Is(err, ErrNameCollision) {
+		t.Fatalf("got %v, want %v", err, ErrNameCollision)
+	}
+	// A new writer, e.g. for a second run with the same options, starts
+	// without any recorded names.
+	if err := NewDefaultResultWriter()(result, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+}
+
+func TestResultIsSuccessIsRetryable(t *testing.T) {
+	var cases = []struct {
+		about     string
+		result    *Result
+		success   bool
+		retryable bool
+	}{
+		{"ok", &Result{StatusCode: 200, Body: []byte("<TEI/>")}, true, false},
+		{"ok, empty body", &Result{StatusCode: 200}, false, false},
+		{"ok, html page", &Result{StatusCode: 200, Body: []byte("<html/>"), Err: ErrUnexpectedContentType}, false, false},
+		{"bad request", &Result{StatusCode: 400, Body: []byte("x")}, false, false},
+		{"too many requests", &Result{StatusCode: 429}, false, true},
+		{"unavailable", &Result{StatusCode: 503}, false, true},
+		{"gateway timeout", &Result{StatusCode: 504}, false, true},
+		{"too large", &Result{StatusCode: 413, Err: ErrPDFTooLarge}, false, false},
+		{"network error", &Result{StatusCode: -1, Err: &url.Error{
+			Op: "Post", URL: "http://localhost:8070", Err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded},
+		}}, false, true},
+	}
+	for _, c := range cases {
+		if got := c.result.IsSuccess(); got != c.success {
+			t.Fatalf("[%s] IsSuccess: got %v, want %v", c.about, got, c.success)
+		}
+		if got := c.result.IsRetryable(); got != c.retryable {
+			t.Fatalf("[%s] IsRetryable: got %v, want %v", c.about, got, c.retryable)
+		}
+	}
+}
+
+func TestTEICorpusResultWriter(t *testing.T) {
+	var (
+		buf          bytes.Buffer
+		rf, finalize = NewTEICorpusResultWriter(&buf)
+		results      = []*Result{
+			{Filename: "a.pdf", StatusCode: 200, Body: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<TEI xmlns="http://www.tei-c.org/ns/1.0"><text>a</text></TEI>`)},
+			{Filename: "b.pdf", StatusCode: 500, Body: []byte("[GENERAL] An exception occurred")},
+			{Filename: "c.pdf", StatusCode: 200, Body: []byte(`<TEI xmlns="http://www.tei-c.org/ns/1.0"><text>c</text></TEI>`)},
+		}
+	)
+	for _, r := range results {
+		if err := rf(r, nil); err != nil {
+			t.Fatalf("[%s] got %v, want nil", r.Filename, err)
+		}
+	}
+	if err := finalize(); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(buf.Bytes()); err != nil {
+		t.Fatalf("invalid corpus: %v\n%s", err, buf.String())
+	}
+	if doc.Root() == nil || doc.Root().Tag != "teiCorpus" {
+		t.Fatalf("got %s, want teiCorpus root", buf.String())
+	}
+	var texts []string
+	for _, el := range doc.Root().SelectElements("TEI") {
+		texts = append(texts, el.FindElement("text").Text())
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(texts, want) {
+		t.Fatalf("got %v, want %v", texts, want)
+	}
+}
+
+func TestJSONLinesResultWriter(t *testing.T) {
+	small, err := os.ReadFile("testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var (
+		buf     bytes.Buffer
+		rf      = NewJSONLinesResultWriter(&buf)
+		results = []*Result{
+			{Filename: "a.pdf", SHA1Hex: "8843d7f92416211de9ebb963ff4ce28125932878", StatusCode: 200, Body: small},
+			{Filename: "b.pdf", StatusCode: 500, Body: []byte("[GENERAL] An exception occurred")},
+			{Filename: "c.pdf", StatusCode: 200, Body: []byte("<TEI><teiHeader>")},
+			{Filename: "d.pdf", StatusCode: -1, Err: errors.New("connection refused")},
+		}
+		wg sync.WaitGroup
+	)
+	for _, r := range results {
+		wg.Add(1)
+		go func(r *Result) {
+			defer wg.Done()
+			if err := rf(r, nil); err != nil {
+				t.Errorf("[%s] got %v, want nil", r.Filename, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(results))
+	}
+	got := make(map[string]string) // filename to title or error
+	for _, line := range lines {
+		var v struct {
+			Filename string              `json:"filename"`
+			Document *tei.GrobidDocument `json:"document"`
+			Err      string              `json:"err"`
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("got %v, want valid JSON: %s", err, line)
+		}
+		switch {
+		case v.Document != nil && v.Document.Header != nil:
+			got[v.Filename] = v.Document.Header.Title
+		default:
+			got[v.Filename] = v.Err
+		}
+	}
+	if got["a.pdf"] == "" {
+		t.Fatalf("got %v, want title for a.pdf", got)
+	}
+	for _, name := range []string{"b.pdf", "c.pdf", "d.pdf"} {
+		if got[name] == "" {
+			t.Fatalf("got %v, want error for %s", got, name)
+		}
+	}
+}
+
+// recordingConnector is a database driver, which records all executed
+// statements, so writers can be tested without a database.
+type recordingConnector struct {
+	mu    sync.Mutex
+	execs [][]driver.Value // query followed by its arguments
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) { return c, nil }
+
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+func (c *recordingConnector) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{c: c, query: query}, nil
+}
+
+func (c *recordingConnector) Close() error { return nil }
+
+func (c *recordingConnector) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+// recordingStmt records its executions with the connector.
+type recordingStmt struct {
+	c     *recordingConnector
+	query string
+}
+
+func (s *recordingStmt) Close() error { return nil }
+
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	s.c.execs = append(s.c.execs, append([]driver.Value{s.query}, args...))
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestSQLiteResultWriter(t *testing.T) {
+	if _, err := NewSQLiteResultWriter(nil, "x; DROP TABLE y"); !errors.Is(err, ErrInvalidTableName) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidTableName)
+	}
+	var (
+		conn = &recordingConnector{}
+		db   = sql.OpenDB(conn)
+	)
+	defer db.Close()
+	rf, err := NewSQLiteResultWriter(db, "results")
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	b, err := os.ReadFile("testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	results := []*Result{
+		{Filename: "a.pdf", SHA1Hex: "aa", StatusCode: 200, Body: b},
+		{Filename: "b.pdf", SHA1Hex: "bb", StatusCode: 500, Body: []byte("[GENERAL] An exception occurred")},
+	}
+	for _, r := range results {
+		if err := rf(r, nil); err != nil {
+			t.Fatalf("[%s] got %v, want nil", r.Filename, err)
+		}
+	}
+	if len(conn.execs) != 5 {
+		t.Fatalf("got %d statements, want 5", len(conn.execs))
+	}
+	for _, e := range conn.execs[:3] {
+		if !strings.Contains(e[0].(string), "IF NOT EXISTS results") {
+			t.Fatalf("got %v, want schema statement", e[0])
+		}
+	}
+	var cases = []struct {
+		about string
+		args  []driver.Value
+	}{
+		{"success", []driver.Value{"a.pdf", "aa", int64(200), "0.5.1-SNAPSHOT", "Dummy Example File", nil}},
+		{"failure", []driver.Value{"b.pdf", "bb", int64(500), nil, nil, nil, nil}},
+	}
+	for i, c := range cases {
+		args := conn.execs[3+i][1:]
+		if len(args) != 7 {
+			t.Fatalf("[%s] got %d args, want 7", c.about, len(args))
+		}
+		if !reflect.DeepEqual(args[:len(c.args)], c.args) {
+			t.Fatalf("[%s] got %v, want %v", c.about, args[:len(c.args)], c.args)
+		}
+	}
+	if js, ok := conn.execs[3][7].(string); !ok || !strings.Contains(js, `"grobid_version":"0.5.1-SNAPSHOT"`) {
+		t.Fatalf("got %v, want JSON document", conn.execs[3][7])
+	}
+}
+
+func TestProcessDate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/processDate" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.FormValue("date") {
+		case "4th of March, 2021":
+			// response of GROBID 0.8.1
+			w.Write([]byte(`<date when="2021-03-04">4th of March, 2021</date>` + "\n"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	var cases = []struct {
+		about  string
+		date   string
+		result string
+		err    bool
+	}{
+		{"valid date", "4th of March, 2021", "2021-03-04", false},
+		{"no date", "the other day", "", true},
+	}
+	for _, c := range cases {
+		result, err := grobid.ProcessDate(c.date, nil)
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestProcessNames(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/processHeaderNames" || r.FormValue("names") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<persName xmlns="http://www.tei-c.org/ns/1.0"><forename type="first">John</forename><surname>Doe</surname></persName>
+<persName xmlns="http://www.tei-c.org/ns/1.0"><forename type="first">Jane</forename><surname>Roe</surname></persName>`))
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	authors, err := grobid.ProcessNames("John Doe and Jane Roe", nil)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var names []string
+	for _, a := range authors {
+		names = append(names, a.Surname)
+	}
+	if want := []string{"Doe", "Roe"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+}
+
+func TestInputFieldNameAcceptHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		if r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	var (
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+		opts   = &Options{InputFieldName: "file", AcceptHeader: "application/json"}
+	)
+	result, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processFulltextDocument", opts)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != http.StatusOK {
+		t.Fatalf("got %v, want 200", result.StatusCode)
+	}
+	if want := "{}"; result.StringBody() != want {
+		t.Fatalf("got %v, want %v", result.StringBody(), want)
+	}
+}
+
+func TestNewTestClient(t *testing.T) {
+	grobid := NewTestClient(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/api/processHeaderDocument" {
+			return &http.Response{StatusCode: http.StatusNotFound}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("<TEI/>")),
+		}, nil
+	})
+	result, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processHeaderDocument", &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if !result.IsSuccess() || result.StringBody() != "<TEI/>" {
+		t.Fatalf("got %v, want successful result", result)
+	}
+	result, err = grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processFulltextDocument", &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v, want 404", result.StatusCode)
+	}
+}
+
+func TestCheckInput(t *testing.T) {
+	var cases = []struct {
+		about   string
+		service string
+		path    string
+		err     error
+	}{
+		{"pdf", "processFulltextDocument", "testdata/pdf/1906.11632.pdf", nil},
+		{"pdf header", "processHeaderDocument", "testdata/pdf/1906.11632.pdf", nil},
+		{"text to citation list", "processCitationList", "testdata/txt/a.txt", nil},
+		{"text to fulltext", "processFulltextDocument", "testdata/txt/a.txt", ErrUnsupportedInput},
+		{"xml to fulltext", "processFulltextDocument", "testdata/xml/a.xml", ErrUnsupportedInput},
+		{"unknown type", "processFulltextDocument", "testdata/a.bin", nil},
+	}
+	for _, c := range cases {
+		err := checkInput(c.service, c.path)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("[%s] got %v, want %v", c.about, err, c.err)
+		}
+	}
+}
+
+func TestUnexpectedContentType(t *testing.T) {
+	var cases = []struct {
+		about       string
+		contentType string
+		body        string
+		err         error
+	}{
+		{"xml", "application/xml", "<TEI/>", nil},
+		{"no content type", "", `<?xml version="1.0"?><TEI/>`, nil},
+		{"html content type", "text/html; charset=utf-8", "<TEI/>", ErrUnexpectedContentType},
+		{"html body", "application/xml", "<!DOCTYPE html><html><body>Bad Gateway</body></html>", ErrUnexpectedContentType},
+		{"xml media type", "text/xml; charset=utf-8", "<TEI/>", nil},
+		{"xml suffix", "application/tei+xml", "<TEI/>", nil},
+		{"empty body", "", "", nil},
+		{"xml body, sniffed as text", "text/plain; charset=utf-8", "<TEI/>", nil},
+		{"plain text", "text/plain", "Service Unavailable", ErrUnexpectedContentType},
+		{"plain text, empty", "text/plain", "", ErrUnexpectedContentType},
+		{"json", "application/json", `{"error": "busy"}`, ErrUnexpectedContentType},
+		{"json body", "", `{"error": "busy"}`, ErrUnexpectedContentType},
+	}
+	for _, c := range cases {
+		grobid := NewTestClient(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {c.contentType}},
+				Body:       io.NopCloser(strings.NewReader(c.body)),
+			}, nil
+		})
+		result, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+			"a.pdf", "processFulltextDocument", &Options{})
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if result.ContentType != c.contentType {
+			t.Fatalf("[%s] got %v, want %v", c.about, result.ContentType, c.contentType)
+		}
+		if result.Err != c.err {
+			t.Fatalf("[%s] got %v, want %v", c.about, result.Err, c.err)
+		}
+	}
+}
+
+func TestStreamToDisk(t *testing.T) {
+	grobid := NewTestClient(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/xml"}},
+			Body:       io.NopCloser(strings.NewReader("<TEI/>")),
+		}, nil
+	})
+	var (
+		dir  = t.TempDir()
+		opts = &Options{OutputDir: dir, StreamToDisk: true, CreateHashSymlinks: true}
+	)
+	result, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processFulltextDocument", opts)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	dst := filepath.Join(dir, "a.grobid.tei.xml")
+	if !result.Streamed || result.OutputPath != dst || len(result.Body) != 0 {
+		t.Fatalf("got %+v, want streamed result", result)
+	}
+	if !result.IsSuccess() {
+		t.Fatalf("got %v, want success", result)
+	}
+	if err := DefaultResultWriter(result, opts); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	for _, name := range []string{dst, filepath.Join(dir, result.SHA1Hex+".grobid.tei.xml")} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(b) != "<TEI/>" {
+			t.Fatalf("[%s] got %s, want <TEI/>", name, b)
+		}
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	var numRequests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if numRequests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	hc := &http.Client{Transport: NewCountingTransport(ts.Client().Transport)}
+	client := pester.NewExtendedClient(hc)
+	client.MaxRetries = 5
+	client.Backoff = func(int) time.Duration { return 0 }
+	grobid := &Grobid{Server: ts.URL, Client: client}
+	result, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processFulltextDocument", &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if !result.IsSuccess() {
+		t.Fatalf("got %v, want success", result)
+	}
+	if result.RetryCount != 2 {
+		t.Fatalf("got %v, want 2", result.RetryCount)
+	}
+}
+
+func TestProcessPDFURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/paper.pdf":
+			w.Write([]byte("%PDF-1.4"))
+		case "/api/processFulltextDocument":
+			f, fh, err := r.FormFile("input")
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			b, _ := io.ReadAll(f)
+			fmt.Fprintf(w, "<TEI>%s %s</TEI>", fh.Filename, b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	grobid := &Grobid{Server: ts.URL, Client: ts.Client()}
+	u := ts.URL + "/paper.pdf"
+	result, err := grobid.ProcessPDFURL(context.Background(), u, "processFulltextDocument", &Options{})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if result.Filename != u {
+		t.Fatalf("got %v, want %v", result.Filename, u)
+	}
+	if want := "<TEI>paper.pdf %PDF-1.4</TEI>"; result.StringBody() != want {
+		t.Fatalf("got %v, want %v", result.StringBody(), want)
+	}
+	_, err = grobid.ProcessPDFURL(context.Background(), ts.URL+"/missing.pdf", "processFulltextDocument", &Options{})
+	if !errors.Is(err, ErrDownloadFailed) {
+		t.Fatalf("got %v, want %v", err, ErrDownloadFailed)
+	}
+}
+
+func TestProcessPDFURLOutputName(t *testing.T) {
+	var cases = []struct {
+		about string
+		url   string
+		want  string
+	}{
+		{"arxiv", "https://arxiv.org/pdf/2101.00001", "out/arxiv.org_pdf_2101.00001_8a844763.grobid.tei.xml"},
+		{"query", "https://example.com/download?id=1", "out/example.com_download_8c80697c.grobid.tei.xml"},
+	}
+	for _, c := range cases {
+		if got := outputFilename(c.url, &Options{OutputDir: "out"}); got != c.want {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, c.want)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/processFulltextDocument":
+			io.Copy(io.Discard, r.Body)
+			io.WriteString(w, "<TEI/>")
+		default:
+			io.WriteString(w, "%PDF-1.4")
+		}
+	}))
+	defer ts.Close()
+	var (
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+		dir    = t.TempDir()
+		opts   = &Options{OutputDir: dir}
+		urls   = []string{
+			ts.URL + "/pdf/2101.00001",
+			ts.URL + "/pdf/2101.00002",
+			ts.URL + "/download?id=1",
+			ts.URL + "/download?id=2",
+		}
+		seen = make(map[string]bool)
+	)
+	for _, u := range urls {
+		result, err := grobid.ProcessPDFURL(context.Background(), u, "processFulltextDocument", opts)
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if err := DefaultResultWriter(result, opts); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if filepath.Dir(result.OutputPath) != dir {
+			t.Fatalf("got %v, want output in %v", result.OutputPath, dir)
+		}
+		seen[result.OutputPath] = true
+	}
+	if len(seen) != len(urls) {
+		t.Fatalf("got %d output files, want %d: %v", len(seen), len(urls), seen)
+	}
+}
+
+func TestTraceFunc(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("<TEI/>"))
+	}))
+	defer ts.Close()
+	var (
+		grobid = &Grobid{Server: ts.URL, Client: ts.Client()}
+		traced []string
+		opts   = &Options{
+			SegmentSentences: true,
+			TraceFunc: func(req *http.Request, fields url.Values) {
+				traced = append(traced, req.URL.Path, fields.Encode())
+			},
+		}
+	)
+	_, err := grobid.ProcessPDFReader(context.Background(), strings.NewReader("%PDF-1.4"),
+		"a.pdf", "processFulltextDocument", opts)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := []string{"/api/processFulltextDocument", "segmentSentences=1"}
+	if !reflect.DeepEqual(traced, want) {
+		t.Fatalf("got %v, want %v", traced, want)
+	}
+}
+
 func skipNoDocker(t *testing.T) {
 	noDocker := false
 	cmd := exec.Command("systemctl", "is-active", "docker")
@@ -3,14 +3,19 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -23,24 +28,41 @@ import (
 var (
 	server             = flag.String("S", "http://localhost:8070", "server URL") // TODO: make this repeatable
 	serviceName        = flag.String("s", "processFulltextDocument", "a valid service name")
-	inputFile          = flag.String("f", "", "single input file to process")
+	inputFile          = flag.String("f", "", "single input file to process, use - to read a PDF from stdin")
 	inputDir           = flag.String("d", "", "input directory to scan for PDF, txt, or XML files")
-	outputDir          = flag.String("O", "", "output directory to write parsed files to")
+	readURLs           = flag.Bool("url", false, "read PDF URLs from stdin, one per line, and process them")
+	jobsFile           = flag.String("jobs", "", "path to a JSON lines file of jobs, like {\"path\": ..., \"service\": ..., \"options\": {...}}")
+	outputDir          = flag.String("O", "", "output directory to write parsed files to, with -d and -json use - to write JSON lines to stdout")
 	createHashSymlinks = flag.Bool("H", false, "use sha1 of file contents as the filename")
+	shardDepth         = flag.Int("D", 0, "with -O or -H, shard output files and symlinks into this many levels of subdirectories, named after the SHA1 of the input, like ab/cd")
 	configFile         = flag.String("c", "", "path to config file, often config.json")
 	numWorkers         = flag.Int("n", recommendedNumWorkers(), "number of concurrent workers")
+	serverThreads      = flag.Int("server-threads", 0, "concurrency setting of the server, if known; unless -n is given, use about 1.5 times as many workers")
 	doPing             = flag.Bool("P", false, "do a ping, then exit")
 	debug              = flag.Bool("debug", false, "use debug result writer, does not create any output files")
 	warcFile           = flag.String("W", "", "path to WARC file to extract PDFs and parse them (experimental)")
 	verbose            = flag.Bool("v", false, "be verbose")
+	quiet              = flag.Bool("quiet", false, "suppress library log output")
+	stateFile          = flag.String("state", "", "path to a state file, to skip already processed files when resuming a run")
+	streamToDisk       = flag.Bool("stream", false, "with -d, write responses directly to output files, to limit memory usage")
 	maxRetries         = flag.Int("r", 10, "max retries")
 	timeout            = flag.Duration("T", 60*time.Second, "client timeout")
+	waitReady          = flag.Duration("wait", 0, "wait up to this duration for the server to become ready, before processing, e.g. 60s")
+	timeoutPerMB       = flag.Duration("timeout-per-mb", 0, "give each PDF the client timeout plus this duration per megabyte of input, including retries, e.g. 10s")
+	budget             = flag.Duration("deadline", 0, "stop processing new files after this duration, e.g. 30m")
 	showVersion        = flag.Bool("version", false, "show version")
+	failFast           = flag.Bool("failfast", false, "with -d, stop on the first error")
+	preserveTree       = flag.Bool("tree", false, "with -d and -O, mirror the input directory structure in the output directory")
+	verifyProcessed    = flag.Bool("verify", false, "skip inputs only if their output file is a complete TEI document, reprocess otherwise")
+	writeJSON          = flag.Bool("json", false, "also write the parsed document as JSON next to each TEI output file, see -O")
+	retryEmpty         = flag.Bool("retry-empty", false, "repeat a request for a PDF a few times, if the server responds with an empty body")
 	jsonFormat         = flag.Bool("j", false, "output json for a single file")
 	// flags passed to GROBID API
 	generateIDs            = flag.Bool("g-gi", false, "grobid: generate ids")
 	consolidateCitations   = flag.Bool("g-cc", false, "grobid: consolidate citations")
 	consolidateHeader      = flag.Bool("g-ch", false, "grobid: consolidate header")
+	consolidateCitationsLv = flag.Int("g-ccl", 0, "grobid: consolidate citations level, 0=off, 1=all fields, 2=DOI only")
+	consolidateHeaderLv    = flag.Int("g-chl", 0, "grobid: consolidate header level, 0=off, 1=all fields, 2=DOI only")
 	includeRawCitations    = flag.Bool("g-irc", false, "grobid: include raw citations")
 	includeRawAffiliations = flag.Bool("g-ira", false, "grobid: include raw affiliations")
 	forceReprocess         = flag.Bool("g-force", false, "grobid: force reprocess")
@@ -48,21 +70,38 @@ var (
 	// TODO: add teicoordniates
 )
 
+var (
+	includePatterns stringList
+	excludePatterns stringList
+)
+
+// stringList is a flag value, that can be given multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func recommendedNumWorkers() int {
 	// keep the concurrency at the client (number of simultaneous calls)
 	// slightly higher than the available number of threads at the server side,
 	// for instance if the server has 16 threads, use a concurrency between 20
 	// and 24 (it's the option n in the above mentioned clients, in my case I
 	// used 24) -- https://github.com/kermitt2/grobid/issues/443#issuecomment-505208132
-	ncpu := runtime.NumCPU()
-	return int(float64(ncpu) * 1.5)
+	return grobidclient.RecommendedWorkers(runtime.NumCPU())
 }
 
 // Config is taken from the Python client implementation, which differs a bit.
-// We do not need sleep time (handled by exponential backoff), and batch size.
+// We do not need batch size. Sleep time is used as a minimum wait between
+// retries, in addition to exponential backoff.
 //
-// If a config file is present, server, timeout and coordinates will be taken
-// from there.
+// If a config file is present, server, timeout, sleep time and coordinates
+// will be taken from there.
 type Config struct {
 	BatchSize    int64    `json:"batch_size"`
 	Coordinates  []string `json:"coordinates"`
@@ -101,7 +140,33 @@ var DefaultConfig = &Config{
 	Timeout:      60,
 	GrobidServer: *server,
 	BatchSize:    100, // unused, we use worker threads
-	SleepTime:    5,   // unused, covered by retry and backoff
+	SleepTime:    5,   // only used as minimum backoff, if read from a config file
+}
+
+// minBackoff returns a backoff strategy, which waits at least a given
+// duration between retries.
+func minBackoff(b pester.BackoffStrategy, floor time.Duration) pester.BackoffStrategy {
+	return func(retry int) time.Duration {
+		return max(b(retry), floor)
+	}
+}
+
+// onlyDeadline returns true, if err only reports a passed deadline, possibly
+// joined with other deadline errors.
+func onlyDeadline(err error) bool {
+	var de *grobidclient.DeadlineError
+	if errors.As(err, &de) && err == error(de) {
+		return true
+	}
+	if u, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range u.Unwrap() {
+			if !onlyDeadline(e) {
+				return false
+			}
+		}
+		return len(u.Unwrap()) > 0
+	}
+	return false
 }
 
 func main() {
@@ -134,11 +199,17 @@ Process a single PDF file and get back JSON (using default server URL):
 
   $ grobidcli -j -f testdata/pdf/062RoisinAronAmericanNaturalist03.pdf
 
+Process a PDF read from stdin:
+
+  $ curl -sL https://arxiv.org/pdf/1906.02444 | grobidcli -f -
+
 Process a directory of PDF files (using default server URL):
 
   $ grobidcli -d testdata/pdf
         `)
 	}
+	flag.Var(&includePatterns, "include", "with -d, only process files whose name matches this glob pattern, repeatable")
+	flag.Var(&excludePatterns, "exclude", "with -d, skip files whose name matches this glob pattern, repeatable")
 	flag.Parse()
 	if *showVersion {
 		fmt.Println(grobidclient.Version)
@@ -150,6 +221,11 @@ Process a directory of PDF files (using default server URL):
 	if !grobidclient.IsValidService(*serviceName) {
 		log.Fatal("invalid service name")
 	}
+	for _, v := range []int{*consolidateCitationsLv, *consolidateHeaderLv} {
+		if v < 0 || v > 2 {
+			log.Fatal("consolidation level must be 0, 1 or 2")
+		}
+	}
 	config := DefaultConfig
 	if *configFile != "" {
 		if err := config.FromFile(*configFile); err != nil {
@@ -159,7 +235,8 @@ Process a directory of PDF files (using default server URL):
 		*timeout = config.TimeoutDuration()
 	}
 	hc := &http.Client{
-		Timeout: *timeout,
+		Timeout:   *timeout,
+		Transport: grobidclient.NewCountingTransport(nil),
 	}
 	client := pester.NewExtendedClient(hc)
 	switch {
@@ -175,37 +252,184 @@ Process a directory of PDF files (using default server URL):
 		client.MaxRetries = *maxRetries
 		client.Backoff = pester.ExponentialBackoff
 		client.RetryOnHTTP429 = true
+		if *configFile != "" && config.SleepTime > 0 {
+			// Wait at least the configured time between retries, like the
+			// Python client does.
+			client.Backoff = minBackoff(pester.ExponentialBackoff,
+				time.Duration(config.SleepTime)*time.Second)
+		}
 	}
 	grobid := grobidclient.Grobid{
 		Server: *server,
 		Client: client,
 	}
+	if *quiet {
+		grobid.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if *waitReady > 0 && !*doPing {
+		ctx, cancel := context.WithTimeout(context.Background(), *waitReady)
+		err := grobid.WaitReady(ctx, time.Second)
+		cancel()
+		if err != nil {
+			log.Fatalf("server not ready: %v", err)
+		}
+	}
+	if *serverThreads > 0 {
+		// GROBID does not report its concurrency, so it is passed in.
+		explicit := false
+		flag.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "n" })
+		if !explicit {
+			*numWorkers = grobidclient.RecommendedWorkers(*serverThreads)
+		}
+		if *verbose {
+			log.Printf("using %d workers", *numWorkers)
+		}
+	}
 	if *doPing {
-		fmt.Printf(`{"server": %q, "status": %q, "t": %q}`,
-			*server, grobid.Pingmoji(), time.Now().Format(time.RFC1123))
-		fmt.Println()
+		hs, err := grobid.Health(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		status := "✅"
+		if hs.Err != nil {
+			status = fmt.Sprintf("⛔ (%v)", hs.Err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(struct {
+			Server  string `json:"server"`
+			Status  string `json:"status"`
+			Alive   bool   `json:"alive"`
+			Version string `json:"version,omitempty"`
+			Latency string `json:"latency"`
+			T       string `json:"t"`
+		}{
+			Server:  *server,
+			Status:  status,
+			Alive:   hs.Alive,
+			Version: hs.Version,
+			Latency: hs.Latency.String(),
+			T:       time.Now().Format(time.RFC1123),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 		os.Exit(0)
 	}
+	// With -O -, results are written to stdout as JSON lines, not to files.
+	toStdout := *outputDir == "-"
+	if toStdout {
+		if *inputDir == "" || !*writeJSON {
+			log.Fatal("-O - requires -d and -json")
+		}
+		if *streamToDisk {
+			log.Fatal("-O - cannot be combined with -stream")
+		}
+		if *createHashSymlinks || *preserveTree || *verifyProcessed {
+			log.Fatal("-O - cannot be combined with -H, -tree or -verify")
+		}
+		*outputDir = ""
+		*writeJSON = false
+		// No files are written, so existing files must not cause inputs
+		// to be skipped.
+		*forceReprocess = true
+	}
 	opts := &grobidclient.Options{
 		GenerateIDs:            *generateIDs,
 		ConsolidateHeader:      *consolidateHeader,
 		ConsolidateCitations:   *consolidateCitations,
 		IncludeRawCitations:    *includeRawCitations,
 		IncluseRawAffiliations: *includeRawAffiliations,
-		TEICoordinates:         []string{"ref", "figure", "persName", "formula", "biblStruct"},
+		TEICoordinates:         slices.Clone(grobidclient.DefaultTEICoordinates),
 		SegmentSentences:       *segmentSentences,
 		Force:                  *forceReprocess,
 		Verbose:                *verbose,
 		OutputDir:              *outputDir,
 		CreateHashSymlinks:     *createHashSymlinks,
+		ShardDepth:             *shardDepth,
+		StateFile:              *stateFile,
+		StreamToDisk:           *streamToDisk && *inputDir != "",
+	}
+	opts.Include = includePatterns
+	opts.Exclude = excludePatterns
+	opts.FailFast = *failFast
+	opts.PreserveTree = *preserveTree
+	opts.WriteJSON = *writeJSON
+	opts.VerifyProcessed = *verifyProcessed
+	opts.RetryOnEmptyBody = *retryEmpty
+	opts.Logger = grobid.Logger
+	opts.ConsolidateHeaderLevel = *consolidateHeaderLv
+	opts.ConsolidateCitationsLevel = *consolidateCitationsLv
+	if *budget > 0 {
+		opts.Deadline = time.Now().Add(*budget)
+	}
+	if *timeoutPerMB > 0 && !*doPing {
+		// The client timeout becomes the base of a per file limit, which
+		// is enforced by the library and spans all attempts for a file;
+		// the per attempt limit would end requests for large files early.
+		opts.Timeout = *timeout
+		opts.TimeoutPerMB = *timeoutPerMB
+		hc.Timeout = 0
+	}
+	if *verbose {
+		opts.TraceFunc = func(req *http.Request, fields url.Values) {
+			log.Printf("%s %s %s", req.Method, req.URL, fields.Encode())
+		}
 	}
 	switch {
 	case *inputFile != "":
-		result, err := grobid.ProcessPDF(*inputFile, *serviceName, opts)
-		if err != nil {
-			log.Fatal(err)
+		var (
+			result *grobidclient.Result
+			err    error
+		)
+		switch {
+		case *inputFile == "-":
+			// Stdin is not seekable, so we buffer the whole PDF first.
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatal(err)
+			}
+			result, err = grobid.ProcessPDFReader(context.Background(),
+				bytes.NewReader(b), "stdin.pdf", *serviceName, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case *serviceName == "processCitationList":
+			result, err = grobid.ProcessText(*inputFile, *serviceName, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			result, err = grobid.ProcessPDF(*inputFile, *serviceName, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
 		}
 		switch {
+		case *jsonFormat && *serviceName == "processCitationList":
+			if !result.IsSuccess() {
+				log.Fatal(result)
+			}
+			citations, err := result.Citations()
+			if err != nil {
+				log.Fatal(err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			if err := enc.Encode(citations); err != nil {
+				log.Fatal(err)
+			}
+		case *jsonFormat && strings.HasPrefix(*serviceName, "processCitationPatent"):
+			if !result.IsSuccess() {
+				log.Fatal(result)
+			}
+			citations, err := tei.ParsePatentCitations(bytes.NewReader(result.Body))
+			if err != nil {
+				log.Fatal(err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			if err := enc.Encode(citations); err != nil {
+				log.Fatal(err)
+			}
 		case *jsonFormat:
 			doc, err := tei.ParseDocument(bytes.NewReader(result.Body))
 			if err != nil {
@@ -215,7 +439,7 @@ Process a directory of PDF files (using default server URL):
 			if err := enc.Encode(doc); err != nil {
 				log.Fatal(err)
 			}
-		case result.StatusCode == 200:
+		case result.IsSuccess():
 			fmt.Println(result.StringBody())
 		default:
 			log.Fatal(result)
@@ -226,14 +450,64 @@ Process a directory of PDF files (using default server URL):
 		switch {
 		case *debug:
 			rwf = grobidclient.DebugResultWriter
+		case toStdout:
+			rwf = grobidclient.NewJSONLinesResultWriter(os.Stdout)
 		default:
 			rwf = grobidclient.DefaultResultWriter
 		}
 		err := grobid.ProcessDirRecursive(*inputDir, *serviceName,
 			*numWorkers, rwf, opts)
+		switch {
+		case onlyDeadline(err):
+			// Running out of time is the expected way to stop.
+			log.Println(err)
+		case err != nil:
+			log.Fatal(err)
+		}
+	case *jobsFile != "":
+		f, err := os.Open(*jobsFile)
 		if err != nil {
 			log.Fatal(err)
 		}
+		defer f.Close()
+		rwf := grobidclient.DefaultResultWriter
+		if *debug {
+			rwf = grobidclient.DebugResultWriter
+		}
+		err = grobid.ProcessJobs(context.Background(), f, *serviceName,
+			*numWorkers, rwf, opts)
+		switch {
+		case onlyDeadline(err):
+			log.Println(err)
+		case err != nil:
+			log.Fatal(err)
+		}
+	case *readURLs:
+		rwf := grobidclient.DebugResultWriter
+		if !*debug {
+			if *outputDir == "" {
+				log.Fatal("-url requires an output directory (-O) or -debug")
+			}
+			rwf = grobidclient.DefaultResultWriter
+		}
+		br := bufio.NewScanner(os.Stdin)
+		for br.Scan() {
+			u := strings.TrimSpace(br.Text())
+			if u == "" {
+				continue
+			}
+			result, err := grobid.ProcessPDFURL(context.Background(), u, *serviceName, opts)
+			if err != nil {
+				log.Printf("%s: %v", u, err)
+				continue
+			}
+			if err := rwf(result, opts); err != nil {
+				log.Printf("%s: %v", u, err)
+			}
+		}
+		if err := br.Err(); err != nil {
+			log.Fatal(err)
+		}
 	case *warcFile != "":
 		// WIP: first run with vanilla docker image
 		//
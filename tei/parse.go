@@ -8,10 +8,18 @@
 package tei
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/beevik/etree"
 )
@@ -23,6 +31,11 @@ const (
 
 var ErrInvalidDocument = errors.New("invalid document")
 
+// SchemaVersion identifies the shape of the JSON serialization of a
+// GrobidDocument, emitted as "schema_version". It is bumped whenever fields
+// are removed, renamed or change their type; added fields are compatible.
+const SchemaVersion = "grobidclient/1"
+
 // ParseCitationList parses TEI-XML of one or more references. This should work
 // with either /api/processCitation or /api/processCitationList API responses
 // from GROBID.
@@ -34,7 +47,7 @@ var ErrInvalidDocument = errors.New("invalid document")
 func ParseCitationList(xmlText string) []*GrobidBiblio {
 	xmlText = strings.Replace(xmlText, `xmlns="http://www.tei-c.org/ns/1.0"`, ``, 1)
 	tree := etree.NewDocument()
-	tree.ReadFromString(xmlText)
+	tree.ReadFromBytes(trimXML([]byte(xmlText)))
 	root := tree.Root()
 	if root == nil {
 		return nil
@@ -53,6 +66,28 @@ func ParseCitationList(xmlText string) []*GrobidBiblio {
 	return refs
 }
 
+// ParsePatentCitations parses the response of the processCitationPatentST36
+// or processCitationPatentPDF services. Both cited patents, with their
+// PatentNumber set, and cited non-patent literature are returned, in document
+// order. The citing patent described in the header is not included.
+func ParsePatentCitations(r io.Reader) ([]*GrobidBiblio, error) {
+	tree, err := readTree(r)
+	if err != nil {
+		return nil, err
+	}
+	root := tree.Root()
+	if root == nil || root.Tag != "TEI" {
+		return nil, ErrInvalidDocument
+	}
+	var refs []*GrobidBiblio
+	for i, bs := range root.FindElements(teiPath(`.//listBibl/biblStruct`)) {
+		ref := parseBiblio(bs)
+		ref.Index = i
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
 // ParseCitation parses a single citation from an XML snippet. Returns nil, if none found.
 func ParseCitation(xmlText string) *GrobidBiblio {
 	cl := ParseCitationList(xmlText)
@@ -72,14 +107,124 @@ func ParseCitations(xmlText string) []*GrobidBiblio {
 	return ParseCitationList(xmlText)
 }
 
+// ParseCitationsJSON parses citations from a JSON response, which is either
+// an array of citations or an object with a "citations" array. Field names
+// are the same as used for GrobidBiblio JSON serialization.
+func ParseCitationsJSON(b []byte) ([]*GrobidBiblio, error) {
+	b = bytes.TrimSpace(b)
+	var refs []*GrobidBiblio
+	if len(b) > 0 && b[0] == '{' {
+		var doc struct {
+			Citations []*GrobidBiblio `json:"citations"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return nil, err
+		}
+		refs = doc.Citations
+	} else if err := json.Unmarshal(b, &refs); err != nil {
+		return nil, err
+	}
+	for i, ref := range refs {
+		if ref != nil {
+			ref.Index = i
+		}
+	}
+	return refs, nil
+}
+
+// ParsePersNames parses a list of persName elements, as returned by the
+// /api/processHeaderNames and /api/processCitationNames GROBID APIs.
+func ParsePersNames(xmlText string) []*GrobidAuthor {
+	xmlText = strings.ReplaceAll(xmlText, `xmlns="http://www.tei-c.org/ns/1.0"`, ``)
+	tree := etree.NewDocument()
+	tree.ReadFromString(xmlText)
+	var authors []*GrobidAuthor
+	for _, el := range tree.FindElements(`//persName`) {
+		if a := parsePersName(el); a != nil {
+			authors = append(authors, a)
+		}
+	}
+	return authors
+}
+
+// ParseOptions control optional post-processing in ParseDocumentWithOptions.
+type ParseOptions struct {
+	// Dehyphenate joins words split by hyphenation at line breaks, like
+	// "qua- lity", in the text of the body, including its pages and blocks,
+	// and in the abstract.
+	Dehyphenate bool
+	// InferCorresponding marks header authors with an email address as
+	// corresponding authors, if no author is marked explicitly.
+	InferCorresponding bool
+}
+
 // ParseDocument reads XML data from a reader and turns it into a GrobidDocument.
 func ParseDocument(r io.Reader) (*GrobidDocument, error) {
-	tree := etree.NewDocument()
-	_, err := tree.ReadFrom(r)
+	return ParseDocumentWithOptions(r, nil)
+}
+
+// ParseDocumentWithOptions reads XML data from a reader and turns it into a
+// GrobidDocument, applying post-processing steps set in opts.
+func ParseDocumentWithOptions(r io.Reader, opts *ParseOptions) (*GrobidDocument, error) {
+	tree, err := readTree(r)
 	if err != nil {
 		return nil, err
 	}
-	tei := tree.Root()
+	return parseTEI(tree.Root(), opts)
+}
+
+// utf8BOM is the UTF-8 encoded byte order mark.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// trimXML removes a leading byte order mark and surrounding whitespace, which
+// some proxies add to responses.
+func trimXML(b []byte) []byte {
+	return bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(b), utf8BOM))
+}
+
+// readTree reads an XML document from r, after passing it through trimXML.
+func readTree(r io.Reader) (*etree.Document, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tree := etree.NewDocument()
+	if err := tree.ReadFromBytes(trimXML(b)); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// ParseCorpus reads a teiCorpus document, as written by a corpus result
+// writer, and calls fn for each member TEI document, in order. Parsing stops
+// at the first member, that cannot be parsed, or at the first error returned
+// by fn. The corpus is read into memory completely.
+func ParseCorpus(r io.Reader, fn func(*GrobidDocument) error) error {
+	tree, err := readTree(r)
+	if err != nil {
+		return err
+	}
+	root := tree.Root()
+	if root == nil || root.Tag != "teiCorpus" {
+		return ErrInvalidDocument
+	}
+	for i, el := range root.SelectElements("TEI") {
+		doc, err := parseTEI(el, nil)
+		if err != nil {
+			return fmt.Errorf("corpus member %d: %w", i, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTEI turns a TEI element into a GrobidDocument.
+func parseTEI(tei *etree.Element, opts *ParseOptions) (*GrobidDocument, error) {
+	if opts == nil {
+		opts = &ParseOptions{}
+	}
 	if tei == nil {
 		return nil, ErrInvalidDocument
 	}
@@ -97,20 +242,42 @@ func ParseDocument(r io.Reader) (*GrobidDocument, error) {
 		version        = strings.TrimSpace(applicationTag.SelectAttr("version").Value)
 		ts             = strings.TrimSpace(applicationTag.SelectAttr("when").Value)
 	)
+	models := make(map[string]string)
+	for _, el := range applicationTags {
+		ident := strings.TrimSpace(el.SelectAttrValue("ident", ""))
+		if _, ok := models[ident]; ident == "" || ok {
+			continue
+		}
+		models[ident] = strings.TrimSpace(el.SelectAttrValue("version", ""))
+	}
 	doc := &GrobidDocument{
+		SchemaVersion: SchemaVersion,
 		GrobidVersion: version,
+		Models:        models,
 		GrobidTs:      ts,
 		Header:        parseBiblio(header),
 		PDFMD5:        findElementText(header, `.//idno[@type="MD5"]`),
 	}
+	// The raw reference of the document itself is only taken from the
+	// source description, not from any other note in the header.
+	doc.Header.Unstructured = strings.TrimSpace(findElementText(header,
+		teiPath(`.//sourceDesc/biblStruct/note[@type="raw_reference"]`)))
+	doc.Classifications = parseClassifications(header)
+	doc.HeaderNotes, doc.PageCount = parseHeaderNotes(header)
+	if el := header.FindElement(teiPath(`.//publicationStmt/availability`)); el != nil {
+		doc.Availability = el.SelectAttrValue("status", "")
+		if lel := el.FindElement(teiPath(`./licence`)); lel != nil {
+			doc.LicenceText = innerText(lel)
+		}
+	}
 	var refs []*GrobidBiblio
-	for i, bs := range tei.FindElements(`.//listBibl/biblStruct`) {
+	for i, bs := range tei.FindElements(teiPath(`.//listBibl/biblStruct`)) {
 		ref := parseBiblio(bs)
 		ref.Index = i
 		refs = append(refs, ref)
 	}
 	doc.Citations = refs
-	textTag := tei.FindElement(`.//text`) // TODO: NS
+	textTag := tei.FindElement(teiPath(`.//text`))
 	if textTag != nil {
 		if lang := textTag.SelectAttrValue("lang", ""); lang != "" {
 			// this is the 'body' language
@@ -118,46 +285,350 @@ func ParseDocument(r io.Reader) (*GrobidDocument, error) {
 		}
 	}
 	var el *etree.Element
-	if el = tei.FindElement(`.//profileDesc/abstract`); el != nil { // TODO: NS
+	if el = tei.FindElement(teiPath(`.//profileDesc/abstract`)); el != nil {
 		doc.Abstract = strings.Join(iterTextTrimSpace(el), " ")
+		for _, s := range el.FindElements(`.//s`) {
+			doc.AbstractSentences = append(doc.AbstractSentences, innerText(s))
+		}
+	}
+	if el = tei.FindElement(teiPath(`.//text/body`)); el != nil {
+		// Footnotes are kept separately, see below.
+		doc.Body = strings.Join(iterTextTrimSpaceFunc(el, isPlacedNote), " ")
+		doc.Pages = parsePages(el)
+		doc.Blocks = parseBlocks(el)
+		doc.sections = parseSections(el)
+		doc.Entities = parseEntities(el)
 	}
-	if el = tei.FindElement(`.//text/body`); el != nil { // TODO: NS
-		doc.Body = strings.Join(iterTextTrimSpace(el), " ")
+	if textTag != nil {
+		doc.Footnotes = parseNotes(textTag)
 	}
-	if el = tei.FindElement(`.//back/div[@type="acknowledgement"]`); el != nil {
+	if el = tei.FindElement(teiPath(`.//back/div[@type="acknowledgement"]`)); el != nil {
 		doc.Acknowledgement = strings.Join(iterTextTrimSpace(el), " ")
+		doc.Funders = parseFunders(el)
 	}
-	if el = tei.FindElement(`.//back/div[@type="annex"]`); el != nil {
+	if el = tei.FindElement(teiPath(`.//back/div[@type="annex"]`)); el != nil {
 		doc.Annex = strings.Join(iterTextTrimSpace(el), " ")
 	}
+	if opts.InferCorresponding && doc.Header != nil {
+		inferCorresponding(doc.Header.Authors)
+	}
+	if opts.Dehyphenate {
+		doc.Abstract = dehyphenate(doc.Abstract)
+		for i, s := range doc.AbstractSentences {
+			doc.AbstractSentences[i] = dehyphenate(s)
+		}
+		doc.Body = dehyphenate(doc.Body)
+		for i, p := range doc.Pages {
+			doc.Pages[i].Text = dehyphenate(p.Text)
+		}
+		for i, b := range doc.Blocks {
+			doc.Blocks[i].Text = dehyphenate(b.Text)
+		}
+		for _, sec := range doc.sections {
+			for i, p := range sec.paragraphs {
+				sec.paragraphs[i] = dehyphenate(p)
+			}
+		}
+	}
 	return doc, nil
 }
 
+// teiPath restricts each step of a simple path, like ".//text/body", to
+// elements in the TEI namespace, so elements are found regardless of the
+// prefix used and elements of the same name from other namespaces are
+// ignored.
+func teiPath(path string) string {
+	steps := strings.Split(path, "/")
+	for i, step := range steps {
+		if step == "" || step == "." || step == ".." {
+			continue
+		}
+		tag, rest, _ := strings.Cut(step, "[")
+		steps[i] = fmt.Sprintf("%s[namespace-uri()=%q]", tag, NS)
+		if rest != "" {
+			steps[i] += "[" + rest
+		}
+	}
+	return strings.Join(steps, "/")
+}
+
+// hyphenatedWord matches a word split by a hyphen and whitespace, as left
+// over from line breaks in PDF.
+var hyphenatedWord = regexp.MustCompile(`(\p{L})-\s+(\p{Ll}+)`)
+
+// dehyphenate joins words split by hyphenation, e.g. "qua- lity" becomes
+// "quality". Hyphens not followed by whitespace, like in "well-being", are
+// kept, as are suspended hyphens, like in "pre- and post-processing".
+func dehyphenate(s string) string {
+	return hyphenatedWord.ReplaceAllStringFunc(s, func(m string) string {
+		sm := hyphenatedWord.FindStringSubmatch(m)
+		switch sm[2] {
+		case "and", "or", "to", "und", "oder", "et", "ou":
+			return m
+		}
+		return sm[1] + sm[2]
+	})
+}
+
+// parseFunders extracts funders and grant numbers marked up with <rs> elements,
+// e.g. in the acknowledgement section. A grant number is attached to the
+// funder it points to via "corresp", or to the most recent funder otherwise.
+func parseFunders(elem *etree.Element) []*GrobidFunder {
+	var (
+		funders []*GrobidFunder
+		byID    = make(map[string]*GrobidFunder)
+		last    *GrobidFunder
+	)
+	for _, e := range elem.FindElements(`.//rs[@type]`) {
+		v := strings.TrimSpace(e.Text())
+		if v == "" {
+			continue
+		}
+		switch e.SelectAttrValue("type", "") {
+		case "funder":
+			last = &GrobidFunder{Name: v}
+			funders = append(funders, last)
+			if id := e.SelectAttrValue("id", ""); id != "" {
+				byID[id] = last
+			}
+		case "grantNumber":
+			f := byID[strings.TrimPrefix(e.SelectAttrValue("corresp", ""), "#")]
+			if f == nil {
+				f = last
+			}
+			if f == nil {
+				// grant number without a funder
+				last = &GrobidFunder{}
+				funders = append(funders, last)
+				f = last
+			}
+			f.GrantNumbers = append(f.GrantNumbers, v)
+		}
+	}
+	return funders
+}
+
+// section is a part of the body with an optional heading.
+type section struct {
+	head       string
+	paragraphs []string
+}
+
+// parseSections returns the heads and paragraphs of the divisions of the
+// body, in document order.
+func parseSections(body *etree.Element) []section {
+	var sections []section
+	for _, div := range body.SelectElements("div") {
+		var sec section
+		if head := div.SelectElement("head"); head != nil {
+			sec.head = innerText(head)
+		}
+		for _, p := range div.SelectElements("p") {
+			if text := innerText(p); text != "" {
+				sec.paragraphs = append(sec.paragraphs, text)
+			}
+		}
+		if sec.head != "" || len(sec.paragraphs) > 0 {
+			sections = append(sections, sec)
+		}
+	}
+	return sections
+}
+
+// parsePages groups the text of an element by page, using the page of the
+// outermost elements carrying coordinates. Text without coordinates is
+// attributed to the page of the preceding text, or the first page, if no
+// coordinates have been seen yet. Returns nil, if there are no coordinates.
+func parsePages(elem *etree.Element) []PageText {
+	var (
+		pages   []PageText
+		index   = make(map[int]int) // page number to slice index
+		pending []string            // text seen before any coordinates
+		current int
+	)
+	add := func(page int, text string) {
+		if text == "" {
+			return
+		}
+		if page == 0 {
+			pending = append(pending, text)
+			return
+		}
+		i, ok := index[page]
+		if !ok {
+			index[page] = len(pages)
+			pages = append(pages, PageText{Page: page, Text: text})
+			return
+		}
+		pages[i].Text = pages[i].Text + " " + text
+	}
+	var walk func(e *etree.Element)
+	walk = func(e *etree.Element) {
+		if page, ok := coordsPage(e.SelectAttrValue("coords", "")); ok {
+			current = page
+			if len(pending) > 0 {
+				add(page, strings.Join(pending, " "))
+				pending = nil
+			}
+			add(page, innerText(e))
+			return
+		}
+		add(current, strings.TrimSpace(e.Text()))
+		for _, ch := range e.ChildElements() {
+			walk(ch)
+			add(current, strings.TrimSpace(ch.Tail()))
+		}
+	}
+	walk(elem)
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Page < pages[j].Page
+	})
+	return pages
+}
+
+// parseBlocks returns a TextBlock for each paragraph in the body, that has
+// coordinates, either on the paragraph itself or on its sentences. A
+// paragraph spanning multiple pages is reported on its first page. Blocks are
+// sorted by page, then by x and y, which puts columns in order for simple
+// layouts; callers may want to sort blocks differently. Returns nil, if there
+// are no coordinates.
+func parseBlocks(body *etree.Element) []TextBlock {
+	var blocks []TextBlock
+	for _, p := range body.FindElements(`.//p`) {
+		boxes := parseCoords(p.SelectAttrValue("coords", ""))
+		if len(boxes) == 0 {
+			for _, e := range p.FindElements(`.//s[@coords]`) {
+				boxes = append(boxes, parseCoords(e.SelectAttrValue("coords", ""))...)
+			}
+		}
+		if len(boxes) == 0 {
+			continue
+		}
+		var (
+			page           = boxes[0].page
+			x0, y0, x1, y1 = boxes[0].x, boxes[0].y, boxes[0].x + boxes[0].w, boxes[0].y + boxes[0].h
+		)
+		for _, b := range boxes[1:] {
+			if b.page != page {
+				continue
+			}
+			x0, y0 = min(x0, b.x), min(y0, b.y)
+			x1, y1 = max(x1, b.x+b.w), max(y1, b.y+b.h)
+		}
+		blocks = append(blocks, TextBlock{
+			Text:   innerText(p),
+			Page:   page,
+			X:      x0,
+			Y:      y0,
+			Width:  x1 - x0,
+			Height: y1 - y0,
+		})
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		switch {
+		case a.Page != b.Page:
+			return a.Page < b.Page
+		case a.X != b.X:
+			return a.X < b.X
+		default:
+			return a.Y < b.Y
+		}
+	})
+	return blocks
+}
+
+// box is a single bounding box from a GROBID coords attribute.
+type box struct {
+	page       int
+	x, y, w, h float64
+}
+
+// parseCoords parses all boxes of a GROBID coords attribute value, like
+// "1,72.00,400.00,220.00,10.00;1,72.00,412.00,120.00,10.00". Malformed
+// boxes are skipped.
+func parseCoords(coords string) []box {
+	var boxes []box
+	for _, v := range strings.Split(coords, ";") {
+		fields := strings.Split(strings.TrimSpace(v), ",")
+		if len(fields) != 5 {
+			continue
+		}
+		page, err := strconv.Atoi(fields[0])
+		if err != nil || page < 1 {
+			continue
+		}
+		var fs [4]float64
+		for i, f := range fields[1:] {
+			if fs[i], err = strconv.ParseFloat(f, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		boxes = append(boxes, box{page: page, x: fs[0], y: fs[1], w: fs[2], h: fs[3]})
+	}
+	return boxes
+}
+
+// coordsPage returns the page number of the first box in a GROBID coords
+// attribute value, like "1,72.00,400.00,220.00,10.00;...".
+func coordsPage(coords string) (int, bool) {
+	if coords == "" {
+		return 0, false
+	}
+	v, _, _ := strings.Cut(coords, ",")
+	page, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || page < 1 {
+		return 0, false
+	}
+	return page, true
+}
+
 // parseAffiliation parses an element into a GrobidAffiliation.
 func parseAffiliation(elem *etree.Element) *GrobidAffiliation {
 	ga := &GrobidAffiliation{}
 	for _, e := range elem.FindElements(`./orgName`) {
 		switch e.SelectAttrValue("type", "") {
 		case "institution":
-			ga.Institution = e.Text()
+			if ga.Institution == "" {
+				ga.Institution = e.Text()
+			}
+			ga.Institutions = append(ga.Institutions, e.Text())
 		case "department":
-			ga.Department = e.Text()
+			if ga.Department == "" {
+				ga.Department = e.Text()
+			}
 		case "laboratory":
-			ga.Laboratory = e.Text()
+			if ga.Laboratory == "" {
+				ga.Laboratory = e.Text()
+			}
 		default:
 			continue
 		}
 	}
+	if e := elem.FindElement(`./note[@type="raw_affiliation"]`); e != nil {
+		// The raw affiliation may start with a footnote marker, which is
+		// not part of the affiliation.
+		e = e.Copy()
+		for _, label := range e.SelectElements("label") {
+			e.RemoveChild(label)
+		}
+		ga.RawAffiliation = innerText(e)
+	}
 	if ga.isEmpty() {
 		return nil
 	}
 	addrTag := elem.FindElement("./address")
 	if addrTag != nil {
 		addr := &GrobidAddress{
-			AddrLine:   findElementText(addrTag, `./addrLine`),
-			PostCode:   findElementText(addrTag, `./postCode`),
-			Settlement: findElementText(addrTag, `./settlement`),
-			Country:    findElementText(addrTag, `./country`),
+			AddrLine:    findElementText(addrTag, `./addrLine`),
+			PostCode:    findElementText(addrTag, `./postCode`),
+			Settlement:  findElementText(addrTag, `./settlement`),
+			Region:      findElementText(addrTag, `./region`),
+			Country:     findElementText(addrTag, `./country`),
+			Coordinates: findElementText(addrTag, `./geo`),
 		}
 		ga.Address = addr
 	}
@@ -176,15 +647,35 @@ func parseAuthor(elem *etree.Element) *GrobidAuthor {
 	if ga == nil {
 		return nil
 	}
-	ga.ORCID = findElementText(elem, `./idno[@type="ORCID"]`) // TODO: NS
-	ga.Email = findElementText(elem, `./email`)               // TODO: NS
-	affiliationTag := elem.FindElement(`./affiliation`)
-	if affiliationTag != nil {
-		ga.Affiliation = parseAffiliation(affiliationTag)
+	ga.ORCID = findElementText(elem, `./idno[@type="ORCID"]`)
+	ga.Email = findElementText(elem, `./email`)
+	ga.Corresponding = slices.Contains(strings.Fields(elem.SelectAttrValue("role", "")), "corresp")
+	for _, e := range elem.FindElements(`./affiliation`) {
+		if aff := parseAffiliation(e); aff != nil {
+			ga.Affiliations = append(ga.Affiliations, aff)
+		}
+	}
+	if len(ga.Affiliations) > 0 {
+		ga.Affiliation = ga.Affiliations[0]
 	}
 	return ga
 }
 
+// inferCorresponding marks authors with an email address as corresponding,
+// if none of the authors is marked already.
+func inferCorresponding(authors []*GrobidAuthor) {
+	for _, a := range authors {
+		if a.Corresponding {
+			return
+		}
+	}
+	for _, a := range authors {
+		if a.Email != "" {
+			a.Corresponding = true
+		}
+	}
+}
+
 // parseEditor may contain multiple authors. Sometimes there is no persName,
 // only a bare string under the <editor> tag. This helper should handle these
 // cases.
@@ -228,7 +719,9 @@ func parsePersName(elem *etree.Element) *GrobidAuthor {
 	return ga
 }
 
-// parseBiblio parses bibliographic elements into a GrobidBiblio struct.
+// parseBiblio parses bibliographic elements into a GrobidBiblio struct. Its
+// paths, like those of parseAuthor, are not restricted to the TEI namespace,
+// as citation snippets are parsed with the namespace removed.
 func parseBiblio(elem *etree.Element) *GrobidBiblio {
 	var authors []*GrobidAuthor
 	for _, ela := range elem.FindElements(`.//author`) {
@@ -238,32 +731,51 @@ func parseBiblio(elem *etree.Element) *GrobidBiblio {
 		}
 		authors = append(authors, a)
 	}
+	// Editors are the editors of the volume, e.g. for a book chapter, and
+	// are only taken from the monogr element.
 	var editors []*GrobidAuthor
-	var editorTags = elem.FindElements(`.//editor`)
+	var editorTags = elem.FindElements(`.//monogr/editor`)
 	for _, et := range editorTags {
 		editors = append(editors, parseEditor(et)...)
 	}
-	var contribEditorTags = elem.FindElements(`.//contributor[@role="editor"]`) // TODO: NS
+	var contribEditorTags = elem.FindElements(`.//monogr/contributor[@role="editor"]`)
 	for _, cet := range contribEditorTags {
 		editors = append(editors, parseEditor(cet)...)
 	}
+	var contributors []*GrobidContributor
+	for _, rs := range elem.FindElements(`.//respStmt`) {
+		var role string
+		if el := rs.SelectElement("resp"); el != nil {
+			role = innerText(el)
+		}
+		for _, pn := range rs.SelectElements("persName") {
+			if a := parsePersName(pn); a != nil && a.FullName != "" {
+				contributors = append(contributors, &GrobidContributor{Role: role, Person: a})
+			}
+		}
+	}
 	biblio := &GrobidBiblio{
 		Authors:      authors,
 		Editors:      editors,
-		ID:           elem.SelectAttrValue(`id`, ""),                          // TODO: check NS
-		Unstructured: findElementText(elem, `.//note[@type="raw_reference"]`), // TODO: NS
+		Contributors: contributors,
+		ID:           elem.SelectAttrValue(`id`, ""), // xml:id, the prefix is not checked
+		Unstructured: findElementText(elem, `.//note[@type="raw_reference"]`),
 		// date below
 		// titles: @level=a for article, @level=m for manuscrupt (book)
 		Title:         findElementText(elem, `.//title[@type="main"]`),
+		Subtitle:      findElementText(elem, `.//title[@type="sub"]`),
 		Journal:       findElementText(elem, `.//title[@level="j"]`),
 		JournalAbbrev: findElementText(elem, `.//title[@level="j"][@type="abbrev"]`),
 		SeriesTitle:   findElementText(elem, `.//title[@level="s"]`),
 		Publisher:     findElementText(elem, `.//publicationStmt/publisher`),
+		PubPlace:      findElementText(elem, `.//publicationStmt/pubPlace`),
 		Institution:   findElementText(elem, `.//respStmt/orgName`),
-		Volume:        findElementText(elem, `.//biblScope[@unit="volume"]`),
-		Issue:         findElementText(elem, `.//biblScope[@unit="issue"]`),
+		Volume:        biblScopeText(elem, "volume"),
+		Issue:         biblScopeText(elem, "issue"),
+		Chapter:       findElementText(elem, `.//biblScope[@unit="chapter"]`),
+		ReportNumber:  findElementText(elem, `.//idno[@type="report"]`),
+		PatentNumber:  parsePatentNumber(elem),
 		// pages below
-		DOI:     findElementText(elem, `.//idno[@type="DOI"]`),
 		PMID:    findElementText(elem, `.//idno[@type="PMID"]`),
 		PMCID:   findElementText(elem, `.//idno[@type="PMCID"]`),
 		ArxivID: findElementText(elem, `.//idno[@type="arXiv"]`),
@@ -273,7 +785,8 @@ func parseBiblio(elem *etree.Element) *GrobidBiblio {
 		ISSN:    findElementText(elem, `.//idno[@type="ISSN"]`),
 		EISSN:   findElementText(elem, `.//idno[@type="eISSN"]`),
 	}
-	bookTitleTag := elem.FindElement(`.//title[@level="m"]`) // TODO: NS
+	biblio.TitleLang, biblio.AltTitle = parseTitleLangs(elem)
+	bookTitleTag := elem.FindElement(`.//title[@level="m"]`)
 	if bookTitleTag != nil && bookTitleTag.SelectAttrValue("type", "") == "" {
 		biblio.BookTitle = bookTitleTag.Text()
 	}
@@ -288,15 +801,27 @@ func parseBiblio(elem *etree.Element) *GrobidBiblio {
 	if biblio.Publisher == "" {
 		biblio.Publisher = findElementText(elem, `.//imprint/publisher`)
 	}
+	if biblio.PubPlace == "" {
+		biblio.PubPlace = findElementText(elem, `.//imprint/pubPlace`)
+	}
 	dateTag := elem.FindElement(`.//date[@type="published"]`)
 	if dateTag != nil {
 		biblio.Date = dateTag.SelectAttrValue("when", "")
 	}
+	biblio.DOI, biblio.ContainerDOI = parseDOIs(elem)
+	biblio.OtherIDs = parseOtherIDs(elem)
+	biblio.ISSN, _ = normalizeISSN(biblio.ISSN)
+	biblio.EISSN, _ = normalizeISSN(biblio.EISSN)
 	if biblio.ArxivID != "" && strings.HasPrefix(biblio.ArxivID, "arXiv:") {
 		biblio.ArxivID = biblio.ArxivID[6:]
 	}
+	if m := arxivVersion.FindStringSubmatch(biblio.ArxivID); m != nil {
+		biblio.ArxivID, biblio.Version = m[1], m[2]
+	}
+	biblio.Edition = findElementText(elem, `.//edition`)
+	biblio.PageCount = parsePageCount(elem)
 	var el *etree.Element
-	el = elem.FindElement(`.//biblScope[@unit="page"]`) // TODO: NS
+	el = elem.FindElement(`.//biblScope[@unit="page"]`)
 	if el != nil {
 		if v := el.SelectAttrValue("from", ""); v != "" {
 			biblio.FirstPage = v
@@ -304,15 +829,38 @@ func parseBiblio(elem *etree.Element) *GrobidBiblio {
 		if v := el.SelectAttrValue("to", ""); v != "" {
 			biblio.LastPage = v
 		}
-		if biblio.FirstPage != "" && biblio.LastPage != "" {
+		switch {
+		case biblio.FirstPage != "" && biblio.LastPage != "":
 			biblio.Pages = fmt.Sprintf("%s-%s", biblio.FirstPage, biblio.LastPage)
-		} else {
+		case biblio.FirstPage != "" && strings.TrimSpace(el.Text()) == "":
+			biblio.Pages = biblio.FirstPage
+		default:
+			// A single page or an e-locator, like "e0123456", is kept
+			// verbatim and used as first page as well.
 			biblio.Pages = el.Text()
+			if v := strings.TrimSpace(el.Text()); biblio.FirstPage == "" && isSinglePage(v) {
+				biblio.FirstPage = v
+			}
 		}
 	}
-	el = elem.FindElement(`.//ptr[@target]`) // TODO: NS
-	if el != nil {
-		biblio.URL = cleanURL(el.SelectAttrValue("target", ""))
+	for _, el := range elem.FindElements(`.//ptr[@target]`) {
+		if el.SelectAttrValue("type", "") == "open-access" {
+			biblio.OpenAccess = true
+			if biblio.OAURL == "" {
+				biblio.OAURL = cleanURL(el.SelectAttrValue("target", ""))
+			}
+		}
+		if v := el.SelectAttrValue("target", ""); biblio.URL == "" && !strings.HasPrefix(v, "#") {
+			// Targets starting with "#" point into the document itself,
+			// like the string ranges of patent citations.
+			biblio.URL = cleanURL(v)
+		}
+	}
+	if el = elem.FindElement(`.//idno[@type="OA"]`); el != nil {
+		biblio.OpenAccess = true
+		if v := strings.TrimSpace(el.Text()); biblio.OAURL == "" && strings.HasPrefix(v, "http") {
+			biblio.OAURL = cleanURL(v)
+		}
 	}
 	if biblio.DOI != "" && biblio.URL != "" {
 		if strings.Contains(biblio.URL, "://doi.org/") || strings.Contains(biblio.URL, "://dx.doi.org/") {
@@ -324,56 +872,428 @@ func parseBiblio(elem *etree.Element) *GrobidBiblio {
 
 // GrobidDocument groups a response from the GROBID API.
 type GrobidDocument struct {
-	GrobidVersion   string          `json:"grobid_version,omitempty"`
-	GrobidTs        string          `json:"grobid_ts,omitempty"`
-	Header          *GrobidBiblio   `json:"header,omitempty"`
-	PDFMD5          string          `json:"pdfmd5,omitempty"`
-	LanguageCode    string          `json:"lang,omitempty"`
-	Citations       []*GrobidBiblio `json:"citations,omitempty"`
-	Abstract        string          `json:"abstract,omitempty"`
-	Body            string          `json:"body,omitempty"`
-	Acknowledgement string          `json:"acknowledgement,omitempty"`
-	Annex           string          `json:"annex,omitempty"`
+	SchemaVersion string          `json:"schema_version,omitempty"` // see SchemaVersion
+	GrobidVersion string          `json:"grobid_version,omitempty"`
+	GrobidTs      string          `json:"grobid_ts,omitempty"`
+	Header        *GrobidBiblio   `json:"header,omitempty"`
+	PDFMD5        string          `json:"pdfmd5,omitempty"`
+	Availability  string          `json:"availability,omitempty"` // e.g. "free", "restricted" or "unknown"
+	LicenceText   string          `json:"licence_text,omitempty"`
+	LanguageCode  string          `json:"lang,omitempty"`
+	Citations     []*GrobidBiblio `json:"citations,omitempty"`
+	Abstract      string          `json:"abstract,omitempty"`
+	// AbstractSentences is only populated, if the document was processed
+	// with sentence segmentation.
+	AbstractSentences []string        `json:"abstract_sentences,omitempty"`
+	Body              string          `json:"body,omitempty"`
+	Pages             []PageText      `json:"pages,omitempty"`
+	Blocks            []TextBlock     `json:"blocks,omitempty"`
+	Acknowledgement   string          `json:"acknowledgement,omitempty"`
+	Funders           []*GrobidFunder `json:"funders,omitempty"`
+	Annex             string          `json:"annex,omitempty"`
+
+	// Models maps the ident of each application listed in the encodingDesc
+	// to its version, e.g. "GROBID" to "0.8.1".
+	Models map[string]string `json:"models,omitempty"`
+
+	// Classifications are subject codes from the header, like arXiv or MSC
+	// classes. Free text keywords are not included.
+	Classifications []Classification `json:"classifications,omitempty"`
+
+	// PageCount is the number of pages of the document, as found in a
+	// note of type "pages" in the file description, or 0.
+	PageCount int `json:"page_count,omitempty"`
+
+	// HeaderNotes are the notes of the file description by type, like
+	// "pages" or "language", including types not otherwise interpreted.
+	HeaderNotes map[string]string `json:"header_notes,omitempty"`
+
+	// Footnotes are the foot and end notes of the text. Their text is not
+	// included in Body.
+	Footnotes []Note `json:"footnotes,omitempty"`
+
+	// Entities are named entities, like chemicals or genes, annotated in
+	// the body by GROBID add-ons as rs elements. Nil, if there are none.
+	Entities []Entity `json:"entities,omitempty"`
+
+	sections []section // body structure, only available after parsing TEI
+}
+
+// Note is a foot or end note, with its place, like "foot" or "end", the
+// note number and the GROBID coordinates, if requested, as found in the
+// coords attribute.
+type Note struct {
+	Text   string `json:"text"`
+	Place  string `json:"place,omitempty"`
+	N      string `json:"n,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Coords string `json:"coords,omitempty"`
+}
+
+// Entity is a named entity annotation in the text, with the type, like
+// "chemical" or "gene", the text as found in the document and, if the
+// annotation links to a knowledge base, the identifier from the key or ref
+// attribute.
+type Entity struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	ID     string `json:"id,omitempty"`
+	Coords string `json:"coords,omitempty"`
+}
+
+// parseEntities returns all rs elements with a type and non-empty text below
+// elem, in document order. Nested annotations are included.
+func parseEntities(elem *etree.Element) []Entity {
+	var entities []Entity
+	var walk func(e *etree.Element)
+	walk = func(e *etree.Element) {
+		if e.Tag == "rs" && e.SelectAttrValue("type", "") != "" {
+			if text := innerText(e); text != "" {
+				id := e.SelectAttrValue("key", "")
+				if id == "" {
+					id = e.SelectAttrValue("ref", "")
+				}
+				entities = append(entities, Entity{
+					Type:   e.SelectAttrValue("type", ""),
+					Text:   text,
+					ID:     id,
+					Coords: e.SelectAttrValue("coords", ""),
+				})
+			}
+		}
+		for _, ch := range e.ChildElements() {
+			walk(ch)
+		}
+	}
+	walk(elem)
+	return entities
+}
+
+// isPlacedNote returns true for notes with a place, like footnotes.
+func isPlacedNote(e *etree.Element) bool {
+	return e.Tag == "note" && e.SelectAttr("place") != nil
+}
+
+// parseNotes returns all non-empty notes with a place below elem, in
+// document order.
+func parseNotes(elem *etree.Element) []Note {
+	var notes []Note
+	// A path query does not return elements in document order, so walk the
+	// tree instead.
+	var walk func(e *etree.Element)
+	walk = func(e *etree.Element) {
+		if !isPlacedNote(e) {
+			for _, ch := range e.ChildElements() {
+				walk(ch)
+			}
+			return
+		}
+		if text := innerText(e); text != "" {
+			notes = append(notes, Note{
+				Text:   text,
+				Place:  e.SelectAttrValue("place", ""),
+				N:      e.SelectAttrValue("n", ""),
+				ID:     e.SelectAttrValue("id", ""),
+				Coords: e.SelectAttrValue("coords", ""),
+			})
+		}
+	}
+	walk(elem)
+	return notes
+}
+
+// Classification is a code in a classification scheme, with an optional
+// label, e.g. "68T05" in "MSC" with label "Learning and adaptive systems".
+type Classification struct {
+	Scheme string `json:"scheme,omitempty"`
+	Code   string `json:"code"`
+	Label  string `json:"label,omitempty"`
+}
+
+// parseClassifications returns all non-empty classCode elements of the
+// header. The code is the text of the element, a term child is used as label.
+func parseClassifications(header *etree.Element) []Classification {
+	var result []Classification
+	for _, el := range header.FindElements(teiPath(`.//textClass/classCode`)) {
+		code := strings.TrimSpace(el.Text())
+		if code == "" {
+			continue
+		}
+		result = append(result, Classification{
+			Scheme: el.SelectAttrValue("scheme", ""),
+			Code:   code,
+			Label:  strings.TrimSpace(findElementText(el, `./term`)),
+		})
+	}
+	return result
+}
+
+// parseHeaderNotes returns the typed notes of the file description, placed
+// in fileDesc directly or in its notesStmt, and the page count from a note of
+// type "pages". Notes in the source description describe the work and are
+// left out. If a type is repeated, the first note is kept.
+func parseHeaderNotes(header *etree.Element) (map[string]string, int) {
+	var (
+		notes     map[string]string
+		pageCount int
+	)
+	for _, path := range []string{`.//fileDesc/note`, `.//fileDesc/notesStmt/note`} {
+		for _, el := range header.FindElements(teiPath(path)) {
+			typ := strings.TrimSpace(el.SelectAttrValue("type", ""))
+			text := strings.TrimSpace(innerText(el))
+			if typ == "" || text == "" {
+				continue
+			}
+			if _, ok := notes[typ]; ok {
+				continue
+			}
+			if notes == nil {
+				notes = make(map[string]string)
+			}
+			notes[typ] = text
+			if typ == "pages" {
+				pageCount, _ = strconv.Atoi(firstNumber.FindString(text))
+			}
+		}
+	}
+	return notes, pageCount
+}
+
+// PageText is the body text found on a single page.
+type PageText struct {
+	Page int    `json:"page"`
+	Text string `json:"text"`
+}
+
+// TextBlock is a body paragraph with its position: the page and the
+// bounding box of its text on that page, in PDF points, as reported by GROBID.
+type TextBlock struct {
+	Text   string  `json:"text"`
+	Page   int     `json:"page"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"w"`
+	Height float64 `json:"h"`
 }
 
 // RemoveEncumbered removes potentially sensible information.
 func (g *GrobidDocument) RemoveEncumbered() {
 	g.Abstract = ""
+	g.AbstractSentences = nil
 	g.Body = ""
+	g.Pages = nil
+	g.Blocks = nil
+	g.Footnotes = nil
 	g.Acknowledgement = ""
 	g.Annex = ""
+	g.sections = nil
+}
+
+// PlainText returns the title, abstract, body and annex as a single text,
+// e.g. for indexing. Blocks are separated by empty lines, section heads and
+// paragraphs are on lines of their own. If the body structure is not known,
+// e.g. for a document decoded from JSON, the body is used as a single block.
+func (g *GrobidDocument) PlainText() string {
+	var blocks []string
+	add := func(lines ...string) {
+		var vs []string
+		for _, v := range lines {
+			if v = strings.TrimSpace(v); v != "" {
+				vs = append(vs, v)
+			}
+		}
+		if len(vs) > 0 {
+			blocks = append(blocks, strings.Join(vs, "\n"))
+		}
+	}
+	if g.Header != nil {
+		add(g.Header.TitleFull())
+	}
+	add(g.Abstract)
+	if len(g.sections) > 0 {
+		for _, sec := range g.sections {
+			add(append([]string{sec.head}, sec.paragraphs...)...)
+		}
+	} else {
+		add(g.Body)
+	}
+	add(g.Annex)
+	return strings.Join(blocks, "\n\n")
+}
+
+// DocStats are simple per document metrics, which can be aggregated over many
+// documents, e.g. to spot a regression in extraction quality.
+type DocStats struct {
+	HasTitle                  bool `json:"has_title"`
+	NumAuthors                int  `json:"num_authors"`
+	NumAuthorsWithAffiliation int  `json:"num_authors_with_affiliation"`
+	NumCitations              int  `json:"num_citations"`
+	NumCitationsWithDOI       int  `json:"num_citations_with_doi"`
+	BodyLength                int  `json:"body_length"` // in characters
+}
+
+// Stats returns metrics about the document.
+func (g *GrobidDocument) Stats() DocStats {
+	var stats DocStats
+	if g.Header != nil {
+		stats.HasTitle = g.Header.Title != ""
+		for _, a := range g.Header.Authors {
+			if a == nil {
+				continue
+			}
+			stats.NumAuthors++
+			if a.Affiliation != nil {
+				stats.NumAuthorsWithAffiliation++
+			}
+		}
+	}
+	for _, c := range g.Citations {
+		if c == nil {
+			continue
+		}
+		stats.NumCitations++
+		if c.DOI != "" {
+			stats.NumCitationsWithDOI++
+		}
+	}
+	stats.BodyLength = utf8.RuneCountInString(g.Body)
+	return stats
+}
+
+// minTitleSimilarity is the minimum word overlap of two titles to consider
+// them as referring to the same work.
+const minTitleSimilarity = 0.8
+
+// EnrichHeader fills empty DOI, ISSN, EISSN and journal fields of the header
+// from a citation with a similar title, e.g. a self-citation. If multiple
+// citations match, the one with most of these fields set is used.
+func (g *GrobidDocument) EnrichHeader() {
+	if g.Header == nil || g.Header.Title == "" {
+		return
+	}
+	var (
+		best      *GrobidBiblio
+		bestCount int
+	)
+	for _, c := range g.Citations {
+		if c == nil || titleSimilarity(g.Header.Title, c.Title) < minTitleSimilarity {
+			continue
+		}
+		if n := countNonEmpty(c.DOI, c.ISSN, c.EISSN, c.Journal); n > bestCount {
+			best, bestCount = c, n
+		}
+	}
+	if best == nil {
+		return
+	}
+	for _, v := range []struct {
+		dst *string
+		src string
+	}{
+		{&g.Header.DOI, best.DOI},
+		{&g.Header.ISSN, best.ISSN},
+		{&g.Header.EISSN, best.EISSN},
+		{&g.Header.Journal, best.Journal},
+	} {
+		if *v.dst == "" {
+			*v.dst = v.src
+		}
+	}
+}
+
+// titleSimilarity returns the Jaccard similarity of the lowercased words of
+// two titles.
+func titleSimilarity(a, b string) float64 {
+	wa, wb := titleWords(a), titleWords(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+	var common int
+	for w := range wa {
+		if wb[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(wa)+len(wb)-common)
+}
+
+// titleWords returns the set of lowercased words in a title, ignoring
+// punctuation.
+func titleWords(s string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[w] = true
+	}
+	return words
+}
+
+// GrobidFunder contains a funder and grant numbers, as mentioned in the
+// acknowledgement.
+type GrobidFunder struct {
+	Name         string   `json:"name,omitempty"`
+	GrantNumbers []string `json:"grant_numbers,omitempty"`
 }
 
 // GrobidAddress contains a parsed address.
 type GrobidAddress struct {
-	AddrLine   string `json:"line,omitempty"`
-	PostCode   string `json:"postcode,omitempty"`
-	Settlement string `json:"settlement,omitempty"`
-	Country    string `json:"country,omitempty"`
+	AddrLine    string `json:"line,omitempty"`
+	PostCode    string `json:"postcode,omitempty"`
+	Settlement  string `json:"settlement,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Country     string `json:"country,omitempty"`
+	Coordinates string `json:"coordinates,omitempty"` // from geo, usually "lat long"
 }
 
-// GrobidAffiliation contains a parsed affiliation.
+// GrobidAffiliation contains a parsed affiliation. If there are multiple
+// organizations of a type, the first one is used; all institutions are kept
+// in Institutions, in document order.
 type GrobidAffiliation struct {
-	Institution string         `json:"institution,omitempty"`
-	Department  string         `json:"department,omitempty"`
-	Laboratory  string         `json:"laboratory,omitempty"`
-	Address     *GrobidAddress `json:"address,omitempty"`
+	Institution    string         `json:"institution,omitempty"`
+	Institutions   []string       `json:"institutions,omitempty"`
+	Department     string         `json:"department,omitempty"`
+	Laboratory     string         `json:"laboratory,omitempty"`
+	Address        *GrobidAddress `json:"address,omitempty"`
+	RawAffiliation string         `json:"raw_affiliation,omitempty"` // requested with IncluseRawAffiliations
 }
 
 // isEmpty is return true, if we do not know anything about an affiliation.
 func (g *GrobidAffiliation) isEmpty() bool {
-	return g.Institution == "" && g.Department == "" && g.Laboratory == "" && g.Address == nil
+	return g.Institution == "" && g.Department == "" && g.Laboratory == "" && g.Address == nil &&
+		g.RawAffiliation == ""
 }
 
-// GrobidAuthor contains parsed author information.
+// GrobidAuthor contains parsed author information. Affiliation is the first
+// of all affiliations of the author.
 type GrobidAuthor struct {
-	FullName    string             `json:"full_name,omitempty"`
-	GivenName   string             `json:"given_name,omitempty"`
-	MiddleName  string             `json:"middle_name,omitempty"`
-	Surname     string             `json:"surname,omitempty"`
-	Email       string             `json:"email,omitempty"`
-	ORCID       string             `json:"orcid,omitempty"`
-	Affiliation *GrobidAffiliation `json:"aff,omitempty"`
+	FullName     string               `json:"full_name,omitempty"`
+	GivenName    string               `json:"given_name,omitempty"`
+	MiddleName   string               `json:"middle_name,omitempty"`
+	Surname      string               `json:"surname,omitempty"`
+	Email        string               `json:"email,omitempty"`
+	ORCID        string               `json:"orcid,omitempty"`
+	Affiliation  *GrobidAffiliation   `json:"aff,omitempty"`
+	Affiliations []*GrobidAffiliation `json:"affs,omitempty"`
+
+	// Corresponding is set for authors marked with the "corresp" role or,
+	// with ParseOptions.InferCorresponding, inferred from an email address.
+	Corresponding bool `json:"corresponding,omitempty"`
+}
+
+// GrobidContributor is a person with a role, as found in a respStmt, e.g.
+// "translator" or "illustrator".
+type GrobidContributor struct {
+	Role   string        `json:"role,omitempty"`
+	Person *GrobidAuthor `json:"person,omitempty"`
+}
+
+// Contributor is a person in the combined, ordered contributor list of a
+// work, as returned by GrobidBiblio.AllContributors.
+type Contributor struct {
+	Role     string        `json:"role"`     // "author", "editor" or a respStmt role
+	Sequence int           `json:"sequence"` // position among contributors with the same role, from 0
+	Person   *GrobidAuthor `json:"person"`
 }
 
 // GrobidBiblio contains the parsed metadata.
@@ -384,29 +1304,298 @@ type GrobidBiblio struct {
 	Unstructured  string          `json:"unstructured,omitempty"`
 	Date          string          `json:"date,omitempty"`
 	Title         string          `json:"title,omitempty"`
+	Subtitle      string          `json:"subtitle,omitempty"`
+	TitleLang     string          `json:"title_lang,omitempty"` // xml:lang of the main title
+	AltTitle      string          `json:"alt_title,omitempty"`  // title in another language, like a translation
 	BookTitle     string          `json:"book_title,omitempty"`
 	SeriesTitle   string          `json:"series_title,omitempty"`
 	Editors       []*GrobidAuthor `json:"editors,omitempty"`
 	Journal       string          `json:"journal,omitempty"`
 	JournalAbbrev string          `json:"journal_abbrev,omitempty"`
 	Publisher     string          `json:"publisher,omitempty"`
+	PubPlace      string          `json:"pub_place,omitempty"`
 	Institution   string          `json:"institution,omitempty"`
 	ISSN          string          `json:"issn,omitempty"`
 	EISSN         string          `json:"eissn,omitempty"`
 	Volume        string          `json:"volume,omitempty"`
 	Issue         string          `json:"issue,omitempty"`
+	Chapter       string          `json:"chapter,omitempty"`
+	ReportNumber  string          `json:"report_number,omitempty"`
+	PatentNumber  string          `json:"patent_number,omitempty"` // like "EP2537698A1"
 	Pages         string          `json:"pages,omitempty"`
 	FirstPage     string          `json:"first_page,omitempty"`
 	LastPage      string          `json:"last_page,omitempty"`
+	PageCount     int             `json:"page_count,omitempty"` // number of pages of a book or report
 	Note          string          `json:"note,omitempty"`
 	DOI           string          `json:"doi,omitempty"`
+	ContainerDOI  string          `json:"container_doi,omitempty"` // DOI of the journal or book
 	PMID          string          `json:"pmid,omitempty"`
 	PMCID         string          `json:"pmcid,omitempty"`
 	ArxivID       string          `json:"arxiv_id,omitempty"`
+	Version       string          `json:"version,omitempty"` // version of an arXiv identifier, like "v2"
+	Edition       string          `json:"edition,omitempty"`
 	PII           string          `json:"pii,omitempty"`
 	Ark           string          `json:"ark,omitempty"`
 	IsTexID       string          `json:"is_tex_id,omitempty"`
 	URL           string          `json:"url,omitempty"`
+	OpenAccess    bool            `json:"open_access,omitempty"`
+	OAURL         string          `json:"oa_url,omitempty"`
+
+	// Contributors are persons with roles other than author or editor, like
+	// translators, from respStmt elements.
+	Contributors []*GrobidContributor `json:"contributors,omitempty"`
+
+	// OtherIDs are all identifiers of the work, grouped by type, in document
+	// order, including those kept in typed fields, like DOI, and those of
+	// uncommon types, like "wikidata". Identifiers of persons are not
+	// included.
+	OtherIDs map[string][]string `json:"other_ids,omitempty"`
+}
+
+// biblScopeText returns the value of a biblScope with a given unit. A range
+// given with from and to attributes is returned like "1-3", otherwise the
+// text is returned verbatim, as scopes are not always numeric, like "Suppl 2".
+func biblScopeText(elem *etree.Element, unit string) string {
+	el := elem.FindElement(fmt.Sprintf(`.//biblScope[@unit="%s"]`, unit))
+	if el == nil {
+		return ""
+	}
+	from, to := el.SelectAttrValue("from", ""), el.SelectAttrValue("to", "")
+	switch {
+	case from != "" && to != "" && from != to:
+		return from + "-" + to
+	case from != "":
+		return from
+	case to != "":
+		return to
+	default:
+		return el.Text()
+	}
+}
+
+// patentNumberPaths are the places to look for a patent number, in order of
+// preference. The docdb and epodoc forms are the most regular ones.
+var patentNumberPaths = []string{
+	`.//idno[@type="docdb"]`,
+	`.//idno[@type="docNumber"][@subtype="docdb"]`,
+	`.//idno[@type="epodoc"]`,
+	`.//idno[@type="docNumber"][@subtype="epodoc"]`,
+	`.//idno[@type="docNumber"]`,
+}
+
+// parsePatentNumber returns the number of a cited patent, including the
+// country code and kind code, if known, like "US5712345A". Punctuation and
+// whitespace are removed.
+func parsePatentNumber(elem *etree.Element) string {
+	var number string
+	for _, p := range patentNumberPaths {
+		if number = findElementText(elem, p); number != "" {
+			break
+		}
+	}
+	number = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, number)
+	if number == "" {
+		return ""
+	}
+	country := strings.TrimSpace(findElementText(elem, `.//authority/orgName`))
+	if country != "" && !strings.HasPrefix(number, country) {
+		number = country + number
+	}
+	kind := strings.TrimSpace(findElementText(elem, `.//classCode[@scheme="kindCode"]`))
+	if kind != "" && !strings.HasSuffix(number, kind) {
+		number += kind
+	}
+	return number
+}
+
+// personTags are elements, whose identifiers belong to a person or
+// organization, not to the work.
+var personTags = []string{"author", "editor", "respStmt", "affiliation"}
+
+// firstNumber matches the first number in a text, like "352" in "xii, 352 p.".
+var firstNumber = regexp.MustCompile(`[0-9]+`)
+
+// parsePageCount returns the number of pages of a work, from an extent, like
+// <extent><measure unit="pages" quantity="352"/></extent> or <extent>352
+// p.</extent>, or from <biblScope unit="pp">, or 0, if there is none. Page
+// ranges are not counted.
+func parsePageCount(elem *etree.Element) int {
+	var v string
+	if el := elem.FindElement(`.//extent/measure[@unit="pages"]`); el != nil {
+		v = el.SelectAttrValue("quantity", el.Text())
+	} else if el := elem.FindElement(`.//extent`); el != nil {
+		v = el.Text()
+	} else {
+		v = findElementText(elem, `.//biblScope[@unit="pp"]`)
+	}
+	n, err := strconv.Atoi(firstNumber.FindString(v))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// parseTitleLangs returns the language of the main title and an alternate
+// title, which is a title of the same level marked with type "alt" or, as
+// GROBID does for multilingual papers, in a different language.
+func parseTitleLangs(elem *etree.Element) (lang, alt string) {
+	main := elem.FindElement(`.//title[@type="main"]`)
+	if main == nil {
+		return "", ""
+	}
+	lang = main.SelectAttrValue("lang", "")
+	level := main.SelectAttrValue("level", "")
+	var byLang string
+	for _, el := range elem.FindElements(`.//title`) {
+		if el == main || el.SelectAttrValue("level", "") != level {
+			continue
+		}
+		v := strings.TrimSpace(el.Text())
+		if v == "" || v == strings.TrimSpace(main.Text()) {
+			continue
+		}
+		switch el.SelectAttrValue("type", "") {
+		case "alt":
+			return lang, v
+		case "sub", "abbrev":
+			continue
+		}
+		if l := el.SelectAttrValue("lang", ""); byLang == "" && l != "" && l != lang {
+			byLang = v
+		}
+	}
+	return lang, byLang
+}
+
+// parseOtherIDs returns all identifiers of a work by type, in document
+// order, including those kept in typed fields, or nil, if there are none.
+func parseOtherIDs(elem *etree.Element) map[string][]string {
+	var (
+		result map[string][]string
+		walk   func(e *etree.Element)
+	)
+	walk = func(e *etree.Element) {
+		for _, child := range e.ChildElements() {
+			switch {
+			case slices.Contains(personTags, child.Tag):
+				continue
+			case child.Tag != "idno":
+				walk(child)
+				continue
+			}
+			v := strings.TrimSpace(child.Text())
+			if v == "" {
+				continue
+			}
+			if result == nil {
+				result = make(map[string][]string)
+			}
+			typ := child.SelectAttrValue("type", "")
+			result[typ] = append(result[typ], v)
+		}
+	}
+	walk(elem)
+	return result
+}
+
+// parseDOIs returns the DOI of the work and the DOI of its container, like a
+// journal or book. DOIs found in monogr belong to the container, unless there
+// is no analytic element, in which case monogr describes the work itself.
+func parseDOIs(elem *etree.Element) (doi, containerDOI string) {
+	for _, el := range elem.FindElements(`.//idno[@type="DOI"]`) {
+		if hasAncestor(el, elem, "monogr") {
+			if containerDOI == "" {
+				containerDOI = el.Text()
+			}
+			continue
+		}
+		if doi == "" {
+			doi = el.Text()
+		}
+	}
+	if doi == "" && elem.FindElement(`.//analytic`) == nil {
+		doi, containerDOI = containerDOI, ""
+	}
+	return cleanDOI(doi), cleanDOI(containerDOI)
+}
+
+// hasAncestor returns true, if elem has an ancestor with the given tag below
+// root.
+func hasAncestor(elem, root *etree.Element, tag string) bool {
+	for p := elem.Parent(); p != nil && p != root; p = p.Parent() {
+		if p.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TitleFull returns the title including the subtitle, if there is one.
+func (g *GrobidBiblio) TitleFull() string {
+	switch {
+	case g.Subtitle == "":
+		return g.Title
+	case g.Title == "":
+		return g.Subtitle
+	default:
+		return g.Title + ": " + g.Subtitle
+	}
+}
+
+// AllContributors returns authors, editors and other contributors, in this
+// order and in document order within each role, as a single list, e.g. for
+// citation formatters. A person listed twice with the same role is only
+// included once. Contributors from respStmt without a role get the role
+// "contributor".
+func (g *GrobidBiblio) AllContributors() []Contributor {
+	var (
+		result []Contributor
+		seen   = make(map[string]bool)
+		count  = make(map[string]int)
+	)
+	add := func(role string, p *GrobidAuthor) {
+		if p == nil {
+			return
+		}
+		if name := personKey(p); name != "" {
+			key := role + "\x00" + name
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+		}
+		result = append(result, Contributor{Role: role, Sequence: count[role], Person: p})
+		count[role]++
+	}
+	for _, a := range g.Authors {
+		add("author", a)
+	}
+	for _, e := range g.Editors {
+		add("editor", e)
+	}
+	for _, c := range g.Contributors {
+		role := c.Role
+		if role == "" {
+			role = "contributor"
+		}
+		add(role, c.Person)
+	}
+	return result
+}
+
+// personKey returns a normalized name of a person for comparison, or the
+// empty string, if the person has no name.
+func personKey(p *GrobidAuthor) string {
+	name := p.FullName
+	if name == "" {
+		name = strings.Join([]string{p.GivenName, p.MiddleName, p.Surname}, " ")
+	}
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
 }
 
 // IsEmpty returns true, if information of this datum is too sketchy.
@@ -426,6 +1615,7 @@ func (g *GrobidBiblio) IsEmpty() bool {
 		g.PMID,
 		g.PMCID,
 		g.ArxivID,
+		g.PatentNumber,
 		g.URL,
 	)
 }
@@ -448,6 +1638,68 @@ func cleanURL(u string) string {
 	return u
 }
 
+// arxivVersion matches an arXiv identifier with a version suffix, like
+// "2101.00001v2" or "hep-th/9901001v1".
+var arxivVersion = regexp.MustCompile(`^(.+?)(v[0-9]+)$`)
+
+// doiPrefixes are prefixes found in front of DOIs, in lowercase.
+var doiPrefixes = []string{
+	"https://doi.org/",
+	"http://doi.org/",
+	"https://dx.doi.org/",
+	"http://dx.doi.org/",
+	"doi.org/",
+	"dx.doi.org/",
+	"doi:",
+}
+
+// cleanDOI normalizes a DOI by removing URL prefixes and trailing punctuation
+// and converting it to lowercase, as DOIs are case insensitive.
+func cleanDOI(doi string) string {
+	doi = strings.ToLower(strings.TrimSpace(doi))
+	for _, p := range doiPrefixes {
+		if strings.HasPrefix(doi, p) {
+			doi = strings.TrimSpace(doi[len(p):])
+			break
+		}
+	}
+	return strings.TrimRight(doi, ".,;:")
+}
+
+// normalizeISSN returns an ISSN in its hyphenated form, like "0317-8471",
+// and true, if the value is a valid ISSN, with or without hyphen. Otherwise
+// the value is returned unchanged and false.
+func normalizeISSN(s string) (string, bool) {
+	v := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), "-", ""))
+	if len(v) != 8 {
+		return s, false
+	}
+	sum := 0
+	for i := 0; i < 7; i++ {
+		if v[i] < '0' || v[i] > '9' {
+			return s, false
+		}
+		sum += int(v[i]-'0') * (8 - i)
+	}
+	var check byte
+	switch c := (11 - sum%11) % 11; c {
+	case 10:
+		check = 'X'
+	default:
+		check = byte('0' + c)
+	}
+	if v[7] != check {
+		return s, false
+	}
+	return v[:4] + "-" + v[4:], true
+}
+
+// isSinglePage returns true, if the value looks like a single page or
+// locator, not a range or list of pages.
+func isSinglePage(v string) bool {
+	return v != "" && !strings.ContainsAny(v, "-–—, ")
+}
+
 // anyString returns true, if any of the given strings is not empty.
 func anyString(vs ...string) bool {
 	for _, v := range vs {
@@ -458,6 +1710,16 @@ func anyString(vs ...string) bool {
 	return false
 }
 
+// countNonEmpty returns the number of non-empty strings.
+func countNonEmpty(vs ...string) (n int) {
+	for _, v := range vs {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
 // findElementText return the text of a node matched by path or the empty
 // string.
 func findElementText(elem *etree.Element, path string) string {
@@ -484,6 +1746,39 @@ func iterText(elem *etree.Element) (result []string) {
 	return result
 }
 
+// iterTextFunc is like iterText, but leaves out the text of elements, for
+// which skip returns true, keeping their tail.
+func iterTextFunc(elem *etree.Element, skip func(*etree.Element) bool) (result []string) {
+	if elem == nil {
+		return
+	}
+	if skip(elem) {
+		return []string{elem.Tail()}
+	}
+	result = append(result, elem.Text())
+	for _, ch := range elem.ChildElements() {
+		result = append(result, iterTextFunc(ch, skip)...)
+	}
+	result = append(result, elem.Tail())
+	return result
+}
+
+// innerText returns the whitespace normalized text of an element and its
+// children, without the tail of the element itself.
+func innerText(elem *etree.Element) string {
+	result := []string{elem.Text()}
+	for _, ch := range elem.ChildElements() {
+		result = append(result, iterText(ch)...)
+	}
+	var vs []string
+	for _, v := range result {
+		if c := strings.TrimSpace(v); c != "" {
+			vs = append(vs, c)
+		}
+	}
+	return strings.Join(vs, " ")
+}
+
 // iterTextTrimSpace returns all child text elements, recursively, in document
 // order, with all whitespace stripped.
 func iterTextTrimSpace(elem *etree.Element) (result []string) {
@@ -499,3 +1794,14 @@ func iterTextTrimSpace(elem *etree.Element) (result []string) {
 	}
 	return result
 }
+
+// iterTextTrimSpaceFunc is like iterTextTrimSpace, but leaves out the text of
+// elements, for which skip returns true.
+func iterTextTrimSpaceFunc(elem *etree.Element, skip func(*etree.Element) bool) (result []string) {
+	for _, v := range iterTextFunc(elem, skip) {
+		if c := strings.TrimSpace(v); c != "" {
+			result = append(result, c)
+		}
+	}
+	return result
+}
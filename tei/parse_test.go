@@ -2,6 +2,7 @@ package tei
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"reflect"
@@ -188,6 +189,143 @@ func TestExampleGrobidTei(t *testing.T) {
 	}
 }
 
+func TestAcknowledgementFunders(t *testing.T) {
+	f, err := os.Open("../testdata/document/acknowledgement.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	if !strings.Contains(doc.Acknowledgement, "National Science Foundation under grants 1234567") {
+		t.Fatalf("acknowledgement: got %v", doc.Acknowledgement)
+	}
+	want := []*GrobidFunder{
+		{Name: "National Science Foundation", GrantNumbers: []string{"1234567", "7654321"}},
+		{Name: "Deutsche Forschungsgemeinschaft", GrantNumbers: []string{"DFG-42"}},
+	}
+	if !reflect.DeepEqual(doc.Funders, want) {
+		b, _ := json.Marshal(doc.Funders)
+		t.Fatalf("funders: got %s", b)
+	}
+}
+
+func TestAvailability(t *testing.T) {
+	f, err := os.Open("../testdata/document/acknowledgement.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	if want := "free"; doc.Availability != want {
+		t.Fatalf("availability: got %v, want %v", doc.Availability, want)
+	}
+	if want := "This article is distributed under the terms of the Creative Commons Attribution 4.0 International License."; doc.LicenceText != want {
+		t.Fatalf("licence: got %v, want %v", doc.LicenceText, want)
+	}
+}
+
+func TestPages(t *testing.T) {
+	f, err := os.Open("../testdata/document/coords.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	want := []PageText{
+		{Page: 1, Text: "Introduction The left column starts on the first page [1] . The right column follows."},
+		{Page: 2, Text: "Results Results are on the second page."},
+		{Page: 3, Text: "Discussion is on the third page. Trailing text without coordinates."},
+	}
+	if !reflect.DeepEqual(doc.Pages, want) {
+		t.Fatalf("got %v, want %v", doc.Pages, want)
+	}
+	f, err = os.Open("../testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err = ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	if doc.Pages != nil {
+		t.Fatalf("got %v, want nil", doc.Pages)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	f, err := os.Open("../testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	want := `Dummy Example File
+
+Everything you ever wanted to know about nothing
+
+Introduction
+Everything starts somewhere, as somebody [1] once said.
+
+In Depth
+
+Meat
+You know, for kids.
+
+Potatos
+QED.`
+	if got := doc.PlainText(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	// Without body structure, e.g. after decoding JSON, the body is used.
+	var decoded GrobidDocument
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want = "Dummy Example File\n\nEverything you ever wanted to know about nothing\n\n" + doc.Body
+	if got := decoded.PlainText(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestAbstractSentences(t *testing.T) {
+	f, err := os.Open("../testdata/document/coords.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	want := []string{
+		"We place text on pages.",
+		"Every sentence has coordinates .",
+	}
+	if !reflect.DeepEqual(doc.AbstractSentences, want) {
+		t.Fatalf("got %q, want %q", doc.AbstractSentences, want)
+	}
+	if want := "We place text on pages. Every sentence has coordinates ."; doc.Abstract != want {
+		t.Fatalf("got %v, want %v", doc.Abstract, want)
+	}
+}
+
 func TestInvalidXML(t *testing.T) {
 	var err error
 	_, err = ParseDocument(strings.NewReader(`this is not XML`))
@@ -239,154 +377,807 @@ func TestParseSmall(t *testing.T) {
 	}
 }
 
-func TestCleanURL(t *testing.T) {
+func TestSchemaVersion(t *testing.T) {
+	b, err := os.ReadFile("../testdata/small.json")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var v struct {
+		SchemaVersion string `json:"schema_version"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if v.SchemaVersion != SchemaVersion {
+		t.Fatalf("got %q, want %q", v.SchemaVersion, SchemaVersion)
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	b, err := os.ReadFile("../testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	want, err := ParseDocument(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
 	var cases = []struct {
-		about  string
-		u      string
-		result string
+		about          string
+		prefix, suffix string
 	}{
-		{
-			about:  "empty",
-			u:      "",
-			result: "",
-		},
-		{
-			about:  "already ok",
-			u:      "http://archive.org",
-			result: "http://archive.org",
-		},
-		{
-			about:  "last accessed",
-			u:      "http://archive.org.Lastaccessed",
-			result: "http://archive.org",
-		},
-		{
-			about:  "< prefix",
-			u:      "<http://archive.org.Lastaccessed",
-			result: "http://archive.org",
-		},
-		// TODO: add more
+		{"bom", "\ufeff", ""},
+		{"bom and whitespace", "\ufeff\r\n", "\n\n"},
+		{"whitespace before bom", "\n\ufeff", " \r\n"},
 	}
 	for _, c := range cases {
-		result := cleanURL(c.u)
-		if result != c.result {
-			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		data := c.prefix + string(b) + c.suffix
+		got, err := ParseDocument(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("[%s] got %v, want %v", c.about, got, want)
+		}
+		refs := ParseCitationList(c.prefix + `<biblStruct><monogr><title level="m" type="main">Handbook of Optics</title></monogr></biblStruct>` + c.suffix)
+		if len(refs) != 1 || refs[0].Title != "Handbook of Optics" {
+			t.Fatalf("[%s] got %v, want a single citation", c.about, refs)
 		}
 	}
 }
 
-func TestAnyString(t *testing.T) {
+func TestParsePatentCitations(t *testing.T) {
+	f, err := os.Open("../testdata/patent/st36.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	refs, err := ParsePatentCitations(f)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
 	var cases = []struct {
-		about  string
-		vs     []string
-		result bool
+		about        string
+		patentNumber string
+		title        string
 	}{
-		{
-			about:  "nil",
-			vs:     nil,
-			result: false,
-		},
-		{
-			about:  "no values",
-			vs:     []string{},
-			result: false,
-		},
-		{
-			about:  "3 empty strings",
-			vs:     []string{"", "", ""},
-			result: false,
-		},
-		{
-			about:  "3 empty strings, 1 non-empty",
-			vs:     []string{"", "", "", "x"},
-			result: true,
-		},
-		{
-			about:  "3 empty strings, 2 non-empty",
-			vs:     []string{"", "", "", "x", "y"},
-			result: true,
-		},
+		{"epodoc number, country and kind code added", "US5712345A", ""},
+		{"epodoc number with country", "EP0123456A1", ""},
+		{"non-patent literature", "", "Thin film transistors on plastic substrates"},
 	}
-	for _, c := range cases {
-		result := anyString(c.vs...)
-		if result != c.result {
-			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+	if len(refs) != len(cases) {
+		t.Fatalf("got %d refs, want %d", len(refs), len(cases))
+	}
+	for i, c := range cases {
+		if refs[i].PatentNumber != c.patentNumber || refs[i].Title != c.title {
+			t.Fatalf("[%s] got %q, %q, want %q, %q", c.about, refs[i].PatentNumber, refs[i].Title, c.patentNumber, c.title)
 		}
+		if refs[i].Index != i {
+			t.Fatalf("[%s] got index %d, want %d", c.about, refs[i].Index, i)
+		}
+		if refs[i].URL != "" {
+			t.Fatalf("[%s] got URL %v, want none", c.about, refs[i].URL)
+		}
+	}
+	if _, err := ParsePatentCitations(strings.NewReader("<biblStruct/>")); err != ErrInvalidDocument {
+		t.Fatalf("got %v, want %v", err, ErrInvalidDocument)
 	}
 }
 
-func TestFindElementText(t *testing.T) {
+func TestParseCitationsJSON(t *testing.T) {
 	var cases = []struct {
 		about  string
-		elem   *etree.Element
-		path   string
-		result string
+		data   string
+		titles []string
+		err    bool
 	}{
-		{
-			about:  "nil element",
-			elem:   mustElementFromString(""),
-			path:   "",
-			result: "",
-		},
+		{"array", `[{"title": "A"}, {"title": "B"}]`, []string{"A", "B"}, false},
+		{"object", `{"citations": [{"title": "A"}]}`, []string{"A"}, false},
+		{"empty array", `[]`, nil, false},
+		{"invalid", `<biblStruct/>`, nil, true},
 	}
 	for _, c := range cases {
-		result := findElementText(c.elem, c.path)
-		if !reflect.DeepEqual(result, c.result) {
-			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		refs, err := ParseCitationsJSON([]byte(c.data))
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+		var titles []string
+		for i, ref := range refs {
+			if ref.Index != i {
+				t.Fatalf("[%s] got index %v, want %v", c.about, ref.Index, i)
+			}
+			titles = append(titles, ref.Title)
+		}
+		if !reflect.DeepEqual(titles, c.titles) {
+			t.Fatalf("[%s] got %v, want %v", c.about, titles, c.titles)
 		}
 	}
 }
 
-func TestIterTextTrimSpace(t *testing.T) {
+func TestClassifications(t *testing.T) {
 	var cases = []struct {
-		about  string
-		input  *etree.Element
-		result []string
+		about    string
+		filename string
+		result   []Classification
 	}{
 		{
-			"empty string",
-			mustElementFromString(""),
-			nil,
+			about:    "class codes",
+			filename: "../testdata/document/classcodes.tei.xml",
+			result: []Classification{
+				{Scheme: "arXiv", Code: "cs.LG"},
+				{Scheme: "MSC", Code: "68T05", Label: "Learning and adaptive systems"},
+				{Scheme: "ACM", Code: "I.7.5"},
+			},
 		},
 		{
-			"1 tag, no text",
-			mustElementFromString("<a></a>"),
-			nil,
+			about:    "no class codes",
+			filename: "../testdata/small.xml",
+			result:   nil,
 		},
+	}
+	for _, c := range cases {
+		f, err := os.Open(c.filename)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		doc, err := ParseDocument(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !reflect.DeepEqual(doc.Classifications, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, doc.Classifications, c.result)
+		}
+	}
+}
+
+func TestHeaderRawReference(t *testing.T) {
+	var cases = []struct {
+		about    string
+		filename string
+		result   string
+	}{
 		{
-			"1 tag, text",
-			mustElementFromString("<a>hello</a>"),
-			[]string{"hello"},
+			about:    "raw reference in source description",
+			filename: "../testdata/document/rawref.tei.xml",
+			result:   "Doe J. A Document with a Raw Reference. Journal of Examples 7 (2021).",
 		},
 		{
-			"2 tags, text",
-			mustElementFromString("<a>hello <b>world</b></a>"),
-			[]string{"hello", "world"},
+			about:    "no raw reference",
+			filename: "../testdata/document/columns.tei.xml",
+			result:   "",
 		},
+	}
+	for _, c := range cases {
+		f, err := os.Open(c.filename)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		doc, err := ParseDocument(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if doc.Header.Unstructured != c.result {
+			t.Fatalf("[%s] got %q, want %q", c.about, doc.Header.Unstructured, c.result)
+		}
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	f, err := os.Open("../testdata/document/notes.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := []Note{
+		{Text: "Inline note on the jurists.", Place: "inline", N: "2", ID: "foot_1"},
+		{Text: "Gaius, Institutes 3.210.", Place: "foot", N: "1", ID: "foot_0", Coords: "1,72.00,700.00,220.00,10.00"},
+		{Text: "All translations are the author's own.", Place: "end", N: "i", ID: "end_0"},
+	}
+	if !reflect.DeepEqual(doc.Footnotes, want) {
+		t.Fatalf("got %v, want %v", doc.Footnotes, want)
+	}
+	body := "Introduction The lex Aquilia governed damage to property. 1 It remained in force for centuries. " +
+		"Later jurists extended its scope. See below."
+	if doc.Body != body {
+		t.Fatalf("got %q, want %q", doc.Body, body)
+	}
+}
+
+func TestEntities(t *testing.T) {
+	var cases = []struct {
+		about    string
+		filename string
+		result   []Entity
+	}{
 		{
-			"2 tags, text, tail",
-			mustElementFromString("<a>hello <b>world</b>!</a>"),
-			[]string{"hello", "world", "!"},
+			about:    "annotated",
+			filename: "../testdata/document/entities.tei.xml",
+			result: []Entity{
+				{Type: "chemical", Text: "acetylsalicylic acid", ID: "CHEBI:15365", Coords: "2,72.00,120.00,40.00,10.00"},
+				{Type: "gene", Text: "PTGS2", ID: "https://www.ncbi.nlm.nih.gov/gene/5743"},
+				{Type: "species", Text: "mice"},
+			},
 		},
 		{
-			"3 tags, text, tail, whitespace",
-			mustElementFromString("<a>hello <b>world</b><b>...  </b>  !</a>"),
-			[]string{"hello", "world", "...", "!"},
+			about:    "no annotations",
+			filename: "../testdata/document/notes.tei.xml",
+			result:   nil,
 		},
 	}
 	for _, c := range cases {
-		result := iterTextTrimSpace(c.input)
-		if !reflect.DeepEqual(result, c.result) {
-			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		f, err := os.Open(c.filename)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		doc, err := ParseDocument(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if !reflect.DeepEqual(doc.Entities, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, doc.Entities, c.result)
 		}
 	}
 }
 
-func TestSingleCitations(t *testing.T) {
-	var data = `
-<biblStruct>
-    <analytic>
-        <title level="a" type="main">Mesh migration following abdominal hernia repair: a comprehensive review</title>
+func TestHeaderNotes(t *testing.T) {
+	var cases = []struct {
+		about     string
+		filename  string
+		pageCount int
+		notes     map[string]string
+	}{
+		{
+			about:     "notes",
+			filename:  "../testdata/document/headernotes.tei.xml",
+			pageCount: 12,
+			notes: map[string]string{
+				"pages":      "12 pages",
+				"language":   "en, de",
+				"submission": "Submitted to the Journal of Examples.",
+			},
+		},
+		{
+			about:    "no notes",
+			filename: "../testdata/document/entities.tei.xml",
+		},
+	}
+	for _, c := range cases {
+		f, err := os.Open(c.filename)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		doc, err := ParseDocument(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		if doc.PageCount != c.pageCount {
+			t.Fatalf("[%s] got %d, want %d", c.about, doc.PageCount, c.pageCount)
+		}
+		if !reflect.DeepEqual(doc.HeaderNotes, c.notes) {
+			t.Fatalf("[%s] got %v, want %v", c.about, doc.HeaderNotes, c.notes)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	f, err := os.Open("../testdata/document/columns.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	want := []TextBlock{
+		{Text: "Left column, top. Still left.", Page: 1, X: 72, Y: 100, Width: 220, Height: 34},
+		{Text: "Left column, bottom.", Page: 1, X: 72, Y: 300, Width: 220, Height: 10},
+		{Text: "Right column, top.", Page: 1, X: 310, Y: 100, Width: 220, Height: 10},
+		{Text: "Left column, continued on the next page.", Page: 2, X: 72, Y: 500, Width: 220, Height: 20},
+		{Text: "Right column on the second page.", Page: 2, X: 310, Y: 90, Width: 220, Height: 40},
+	}
+	if !reflect.DeepEqual(doc.Blocks, want) {
+		t.Fatalf("got %+v, want %+v", doc.Blocks, want)
+	}
+	f, err = os.Open("../testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err = ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want %v", err, nil)
+	}
+	if doc.Blocks != nil {
+		t.Fatalf("got %v, want nil", doc.Blocks)
+	}
+}
+
+func TestParseCorpus(t *testing.T) {
+	var members []string
+	for _, fn := range []string{"../testdata/small.xml", "../testdata/document/coords.tei.xml"} {
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		_, member, _ := strings.Cut(string(b), "?>")
+		members = append(members, member)
+	}
+	var cases = []struct {
+		about  string
+		data   string
+		titles []string
+		err    bool
+	}{
+		{
+			about:  "two members",
+			data:   `<teiCorpus xmlns="http://www.tei-c.org/ns/1.0">` + strings.Join(members, "\n") + `</teiCorpus>`,
+			titles: []string{"Dummy Example File", "A Document with Coordinates"},
+		},
+		{
+			about: "empty corpus",
+			data:  `<teiCorpus xmlns="http://www.tei-c.org/ns/1.0"></teiCorpus>`,
+		},
+		{
+			about:  "malformed member",
+			data:   `<teiCorpus xmlns="http://www.tei-c.org/ns/1.0">` + members[0] + `<TEI><text/></TEI></teiCorpus>`,
+			titles: []string{"Dummy Example File"},
+			err:    true,
+		},
+		{
+			about: "not a corpus",
+			data:  members[0],
+			err:   true,
+		},
+	}
+	for _, c := range cases {
+		var titles []string
+		err := ParseCorpus(strings.NewReader(c.data), func(doc *GrobidDocument) error {
+			titles = append(titles, doc.Header.Title)
+			return nil
+		})
+		if (err != nil) != c.err {
+			t.Fatalf("[%s] got %v, want error %v", c.about, err, c.err)
+		}
+		if !reflect.DeepEqual(titles, c.titles) {
+			t.Fatalf("[%s] got %v, want %v", c.about, titles, c.titles)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	f, err := os.Open("../testdata/small.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var cases = []struct {
+		about string
+		doc   *GrobidDocument
+		want  DocStats
+	}{
+		{
+			about: "small.xml",
+			doc:   doc,
+			want: DocStats{
+				HasTitle:                  true,
+				NumAuthors:                2,
+				NumAuthorsWithAffiliation: 1,
+				NumCitations:              2,
+				BodyLength:                115,
+			},
+		},
+		{
+			about: "empty",
+			doc:   &GrobidDocument{},
+			want:  DocStats{},
+		},
+		{
+			about: "citations with doi, body length in characters",
+			doc: &GrobidDocument{
+				Citations: []*GrobidBiblio{{DOI: "10.1/a"}, {}, {DOI: "10.1/b"}},
+				Body:      "Größe",
+			},
+			want: DocStats{NumCitations: 3, NumCitationsWithDOI: 2, BodyLength: 5},
+		},
+	}
+	for _, c := range cases {
+		if got := c.doc.Stats(); got != c.want {
+			t.Fatalf("[%s] got %+v, want %+v", c.about, got, c.want)
+		}
+	}
+}
+
+func TestParsePrefixedNamespace(t *testing.T) {
+	var docs []*GrobidDocument
+	for _, fn := range []string{"../testdata/small.xml", "../testdata/document/prefixed.tei.xml"} {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		defer f.Close()
+		doc, err := ParseDocument(f)
+		if err != nil {
+			t.Fatalf("parse %s: %v", fn, err)
+		}
+		docs = append(docs, doc)
+	}
+	if !reflect.DeepEqual(docs[0], docs[1]) {
+		t.Fatalf("got %+v, want %+v", docs[1], docs[0])
+	}
+	if docs[1].Body == "" || docs[1].Abstract == "" || len(docs[1].Citations) == 0 {
+		t.Fatalf("expected body, abstract and citations in prefixed document")
+	}
+}
+
+func TestModels(t *testing.T) {
+	var data = `<TEI xmlns="http://www.tei-c.org/ns/1.0">
+<teiHeader><encodingDesc><appInfo>
+<application version="0.8.1" ident="GROBID" when="2024-08-27T16:56+0000"/>
+<application version="2.1.0" ident="header-model"/>
+<application version="0.3.0" ident="citation-model"/>
+<application version="9.9.9" ident="GROBID"/>
+<application version="1.0.0"/>
+</appInfo></encodingDesc></teiHeader>
+</TEI>`
+	doc, err := ParseDocument(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	want := map[string]string{
+		"GROBID":         "0.8.1",
+		"header-model":   "2.1.0",
+		"citation-model": "0.3.0",
+	}
+	if !reflect.DeepEqual(doc.Models, want) {
+		t.Fatalf("got %v, want %v", doc.Models, want)
+	}
+	if doc.GrobidVersion != "0.8.1" {
+		t.Fatalf("got %v, want 0.8.1", doc.GrobidVersion)
+	}
+}
+
+func TestCorresponding(t *testing.T) {
+	var header = `<TEI xmlns="http://www.tei-c.org/ns/1.0">
+<teiHeader><encodingDesc><appInfo><application version="0.8.1" ident="GROBID" when="2024-08-27T16:56+0000"/></appInfo></encodingDesc>
+<fileDesc><sourceDesc><biblStruct><analytic>%s</analytic></biblStruct></sourceDesc></fileDesc></teiHeader>
+</TEI>`
+	var (
+		alice   = `<author><persName><surname>Alice</surname></persName><email>alice@example.com</email></author>`
+		bob     = `<author role="corresp"><persName><surname>Bob</surname></persName></author>`
+		charlie = `<author><persName><surname>Charlie</surname></persName></author>`
+	)
+	var cases = []struct {
+		about  string
+		data   string
+		infer  bool
+		result []bool
+	}{
+		{"marked", alice + bob + charlie, false, []bool{false, true, false}},
+		{"marked, no inference needed", alice + bob + charlie, true, []bool{false, true, false}},
+		{"unmarked", alice + charlie, false, []bool{false, false}},
+		{"inferred from email", alice + charlie, true, []bool{true, false}},
+	}
+	for _, c := range cases {
+		doc, err := ParseDocumentWithOptions(strings.NewReader(fmt.Sprintf(header, c.data)),
+			&ParseOptions{InferCorresponding: c.infer})
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		var result []bool
+		for _, a := range doc.Header.Authors {
+			result = append(result, a.Corresponding)
+		}
+		if !reflect.DeepEqual(result, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestParseForeignNamespace(t *testing.T) {
+	var data = `<TEI xmlns="http://www.tei-c.org/ns/1.0" xmlns:x="urn:example:other">
+<teiHeader><encodingDesc><appInfo><application version="0.8.1" ident="GROBID" when="2024-08-27T16:56+0000"/></appInfo></encodingDesc></teiHeader>
+<x:text><x:body>Not TEI.</x:body></x:text>
+<text><body><div><p>The body.</p></div></body></text>
+</TEI>`
+	doc, err := ParseDocument(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if want := "The body."; doc.Body != want {
+		t.Fatalf("got %v, want %v", doc.Body, want)
+	}
+}
+
+func TestCleanURL(t *testing.T) {
+	var cases = []struct {
+		about  string
+		u      string
+		result string
+	}{
+		{
+			about:  "empty",
+			u:      "",
+			result: "",
+		},
+		{
+			about:  "already ok",
+			u:      "http://archive.org",
+			result: "http://archive.org",
+		},
+		{
+			about:  "last accessed",
+			u:      "http://archive.org.Lastaccessed",
+			result: "http://archive.org",
+		},
+		{
+			about:  "< prefix",
+			u:      "<http://archive.org.Lastaccessed",
+			result: "http://archive.org",
+		},
+		// TODO: add more
+	}
+	for _, c := range cases {
+		result := cleanURL(c.u)
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestNormalizeISSN(t *testing.T) {
+	var cases = []struct {
+		about  string
+		issn   string
+		result string
+		ok     bool
+	}{
+		{"empty", "", "", false},
+		{"hyphenated", "0317-8471", "0317-8471", true},
+		{"without hyphen", "03178471", "0317-8471", true},
+		{"whitespace", " 0317-8471 ", "0317-8471", true},
+		{"X check digit", "2434-561X", "2434-561X", true},
+		{"lowercase x check digit", "2434561x", "2434-561X", true},
+		{"zero check digit", "1234-5660", "1234-5660", true},
+		{"wrong check digit", "0317-8472", "0317-8472", false},
+		{"too short", "0317-847", "0317-847", false},
+		{"not a number", "ISSN 0317", "ISSN 0317", false},
+		{"X not in last place", "X317-8471", "X317-8471", false},
+	}
+	for _, c := range cases {
+		result, ok := normalizeISSN(c.issn)
+		if result != c.result || ok != c.ok {
+			t.Fatalf("[%s] got %q, %v, want %q, %v", c.about, result, ok, c.result, c.ok)
+		}
+	}
+	doc := ParseCitation(`<biblStruct><monogr><title level="j">J</title>
+<idno type="ISSN">03178471</idno><idno type="eISSN">1234-5678</idno></monogr></biblStruct>`)
+	if doc.ISSN != "0317-8471" || doc.EISSN != "1234-5678" {
+		t.Fatalf("got %v, %v, want 0317-8471, 1234-5678", doc.ISSN, doc.EISSN)
+	}
+}
+
+func TestCleanDOI(t *testing.T) {
+	var cases = []struct {
+		about  string
+		doi    string
+		result string
+	}{
+		{
+			about:  "empty",
+			doi:    "",
+			result: "",
+		},
+		{
+			about:  "already ok",
+			doi:    "10.1007/s10029-019-01898-9",
+			result: "10.1007/s10029-019-01898-9",
+		},
+		{
+			about:  "mixed case",
+			doi:    "10.1371/journal.PONE.0123456",
+			result: "10.1371/journal.pone.0123456",
+		},
+		{
+			about:  "https prefix",
+			doi:    "https://doi.org/10.1000/XYZ123",
+			result: "10.1000/xyz123",
+		},
+		{
+			about:  "dx prefix",
+			doi:    "http://dx.doi.org/10.1000/xyz123",
+			result: "10.1000/xyz123",
+		},
+		{
+			about:  "doi prefix and trailing punctuation",
+			doi:    " doi:10.1000/xyz123. ",
+			result: "10.1000/xyz123",
+		},
+	}
+	for _, c := range cases {
+		result := cleanDOI(c.doi)
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestDehyphenate(t *testing.T) {
+	var cases = []struct {
+		about  string
+		s      string
+		result string
+	}{
+		{"empty", "", ""},
+		{"no hyphen", "quality improvement", "quality improvement"},
+		{"line break", "qua-\nlity improvement", "quality improvement"},
+		{"space", "Using patient feedback for qua- lity improvement.", "Using patient feedback for quality improvement."},
+		{"compound", "well-being", "well-being"},
+		{"compound with space after", "well-being and ill- ness", "well-being and illness"},
+		{"suspended hyphen", "pre- and post-processing", "pre- and post-processing"},
+		{"dash", "a - b", "a - b"},
+		{"capitalized continuation", "Anglo- Saxon", "Anglo- Saxon"},
+		{"non-ascii", "Uhlí- řová", "Uhlířová"},
+	}
+	for _, c := range cases {
+		result := dehyphenate(c.s)
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestParseDocumentDehyphenate(t *testing.T) {
+	b, err := os.ReadFile("../testdata/document/coords.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	data := strings.Replace(string(b), "on the second page", "on the sec- ond page", 1)
+	doc, err := ParseDocumentWithOptions(strings.NewReader(data), &ParseOptions{Dehyphenate: true})
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if !strings.Contains(doc.Body, "on the second page") {
+		t.Fatalf("got %v, want dehyphenated body", doc.Body)
+	}
+	var pages, blocks []string
+	for _, p := range doc.Pages {
+		pages = append(pages, p.Text)
+	}
+	for _, b := range doc.Blocks {
+		blocks = append(blocks, b.Text)
+	}
+	for _, texts := range [][]string{pages, blocks} {
+		if !strings.Contains(strings.Join(texts, " "), "on the second page") {
+			t.Fatalf("got %q, want dehyphenated text", texts)
+		}
+	}
+}
+
+func TestAnyString(t *testing.T) {
+	var cases = []struct {
+		about  string
+		vs     []string
+		result bool
+	}{
+		{
+			about:  "nil",
+			vs:     nil,
+			result: false,
+		},
+		{
+			about:  "no values",
+			vs:     []string{},
+			result: false,
+		},
+		{
+			about:  "3 empty strings",
+			vs:     []string{"", "", ""},
+			result: false,
+		},
+		{
+			about:  "3 empty strings, 1 non-empty",
+			vs:     []string{"", "", "", "x"},
+			result: true,
+		},
+		{
+			about:  "3 empty strings, 2 non-empty",
+			vs:     []string{"", "", "", "x", "y"},
+			result: true,
+		},
+	}
+	for _, c := range cases {
+		result := anyString(c.vs...)
+		if result != c.result {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestFindElementText(t *testing.T) {
+	var cases = []struct {
+		about  string
+		elem   *etree.Element
+		path   string
+		result string
+	}{
+		{
+			about:  "nil element",
+			elem:   mustElementFromString(""),
+			path:   "",
+			result: "",
+		},
+	}
+	for _, c := range cases {
+		result := findElementText(c.elem, c.path)
+		if !reflect.DeepEqual(result, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestIterTextTrimSpace(t *testing.T) {
+	var cases = []struct {
+		about  string
+		input  *etree.Element
+		result []string
+	}{
+		{
+			"empty string",
+			mustElementFromString(""),
+			nil,
+		},
+		{
+			"1 tag, no text",
+			mustElementFromString("<a></a>"),
+			nil,
+		},
+		{
+			"1 tag, text",
+			mustElementFromString("<a>hello</a>"),
+			[]string{"hello"},
+		},
+		{
+			"2 tags, text",
+			mustElementFromString("<a>hello <b>world</b></a>"),
+			[]string{"hello", "world"},
+		},
+		{
+			"2 tags, text, tail",
+			mustElementFromString("<a>hello <b>world</b>!</a>"),
+			[]string{"hello", "world", "!"},
+		},
+		{
+			"3 tags, text, tail, whitespace",
+			mustElementFromString("<a>hello <b>world</b><b>...  </b>  !</a>"),
+			[]string{"hello", "world", "...", "!"},
+		},
+	}
+	for _, c := range cases {
+		result := iterTextTrimSpace(c.input)
+		if !reflect.DeepEqual(result, c.result) {
+			t.Fatalf("[%s] got %v, want %v", c.about, result, c.result)
+		}
+	}
+}
+
+func TestSingleCitations(t *testing.T) {
+	var data = `
+<biblStruct>
+    <analytic>
+        <title level="a" type="main">Mesh migration following abdominal hernia repair: a comprehensive review</title>
         <author>
             <persName
                 xmlns="http://www.tei-c.org/ns/1.0">
@@ -396,90 +1187,895 @@ func TestSingleCitations(t *testing.T) {
             </persName>
         </author>
         <author>
-            <persName
-                xmlns="http://www.tei-c.org/ns/1.0">
-                <forename type="first">J</forename>
-                <forename type="middle">J</forename>
-                <surname>Weis</surname>
-            </persName>
+            <persName
+                xmlns="http://www.tei-c.org/ns/1.0">
+                <forename type="first">J</forename>
+                <forename type="middle">J</forename>
+                <surname>Weis</surname>
+            </persName>
+        </author>
+        <author>
+            <persName
+                xmlns="http://www.tei-c.org/ns/1.0">
+                <forename type="first">L</forename>
+                <forename type="middle">R</forename>
+                <surname>Taveras</surname>
+            </persName>
+        </author>
+        <author>
+            <persName
+                xmlns="http://www.tei-c.org/ns/1.0">
+                <forename type="first">S</forename>
+                <surname>Huerta</surname>
+            </persName>
+        </author>
+        <idno type="DOI">10.1007/s10029-019-01898-9</idno>
+        <idno type="PMID">30701369</idno>
+    </analytic>
+    <monogr>
+        <title level="j">Hernia</title>
+        <imprint>
+            <biblScope unit="volume">23</biblScope>
+            <biblScope unit="issue">2</biblScope>
+            <biblScope unit="page" from="235" to="243" />
+            <date type="published" when="2019-01-30" />
+        </imprint>
+    </monogr>
+</biblStruct>"
+	`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatal("expected non nil result")
+	}
+	if doc.IsEmpty() != false {
+		t.Fatal("empty: want false")
+	}
+	if want := `Mesh migration following abdominal hernia repair: a comprehensive review`; doc.Title != want {
+		t.Fatalf("got %s, want %v", doc.Title, want)
+	}
+	if len(doc.Authors) < 3 {
+		t.Fatalf("expeted at least 3 authors")
+	}
+	if want := `L`; doc.Authors[2].GivenName != want {
+		t.Fatalf("got %v, want %v", doc.Authors[2].GivenName, want)
+	}
+	if want := `R`; doc.Authors[2].MiddleName != want {
+		t.Fatalf("got %v, want %v", doc.Authors[2].MiddleName, want)
+	}
+	if want := `Taveras`; doc.Authors[2].Surname != want {
+		t.Fatalf("got %v, want %v", doc.Authors[2].Surname, want)
+	}
+	if want := `L R Taveras`; doc.Authors[2].FullName != want {
+		t.Fatalf("got %v, want %v", doc.Authors[2].FullName, want)
+	}
+	if want := `10.1007/s10029-019-01898-9`; doc.DOI != want {
+		t.Fatalf("got %v, want %v", doc.DOI, want)
+	}
+	if want := `30701369`; doc.PMID != want {
+		t.Fatalf("got %v, want %v", doc.PMID, want)
+	}
+	if want := `2019-01-30`; doc.Date != want {
+		t.Fatalf("got %v, want %v", doc.Date, want)
+	}
+	if want := `235-243`; doc.Pages != want {
+		t.Fatalf("got %v, want %v", doc.Pages, want)
+	}
+	if want := `235`; doc.FirstPage != want {
+		t.Fatalf("got %v, want %v", doc.FirstPage, want)
+	}
+	if want := `243`; doc.LastPage != want {
+		t.Fatalf("got %v, want %v", doc.LastPage, want)
+	}
+	if want := `2`; doc.Issue != want {
+		t.Fatalf("got %v, want %v", doc.Issue, want)
+	}
+	if want := `Hernia`; doc.Journal != want {
+		t.Fatalf("got %v, want %v", doc.Journal, want)
+	}
+}
+
+func TestSinglePage(t *testing.T) {
+	var cases = []struct {
+		about     string
+		scope     string
+		pages     string
+		firstPage string
+		lastPage  string
+	}{
+		{
+			about:     "lone page",
+			scope:     `<biblScope unit="page">42</biblScope>`,
+			pages:     "42",
+			firstPage: "42",
+			lastPage:  "",
+		},
+		{
+			about:     "lone page as attribute",
+			scope:     `<biblScope unit="page" from="42" />`,
+			pages:     "42",
+			firstPage: "42",
+			lastPage:  "",
+		},
+		{
+			about:     "e-locator",
+			scope:     `<biblScope unit="page">e12345</biblScope>`,
+			pages:     "e12345",
+			firstPage: "e12345",
+			lastPage:  "",
+		},
+		{
+			about:     "range as text",
+			scope:     `<biblScope unit="page">206-225</biblScope>`,
+			pages:     "206-225",
+			firstPage: "",
+			lastPage:  "",
+		},
+		{
+			about:     "range",
+			scope:     `<biblScope unit="page" from="206" to="225" />`,
+			pages:     "206-225",
+			firstPage: "206",
+			lastPage:  "225",
+		},
+	}
+	for _, c := range cases {
+		data := fmt.Sprintf(`<biblStruct><monogr><title level="j">PLoS ONE</title><imprint>%s</imprint></monogr></biblStruct>`, c.scope)
+		doc := ParseCitation(data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.Pages != c.pages || doc.FirstPage != c.firstPage || doc.LastPage != c.lastPage {
+			t.Fatalf("[%s] got %q %q %q, want %q %q %q", c.about,
+				doc.Pages, doc.FirstPage, doc.LastPage, c.pages, c.firstPage, c.lastPage)
+		}
+	}
+}
+
+func TestEnrichHeader(t *testing.T) {
+	var cases = []struct {
+		about     string
+		header    *GrobidBiblio
+		citations []*GrobidBiblio
+		result    *GrobidBiblio
+	}{
+		{
+			about:  "header DOI empty, recoverable from refs",
+			header: &GrobidBiblio{Title: "Everything is Wonderful"},
+			citations: []*GrobidBiblio{
+				{Title: "All about Facts", DOI: "10.123/facts", Journal: "The Dictionary"},
+				{Title: "Everything is wonderful.", DOI: "10.123/wonderful"},
+				{Title: "Everything is Wonderful", DOI: "10.123/wonderful", Journal: "Letters in the Alphabet", ISSN: "1234-5678"},
+			},
+			result: &GrobidBiblio{
+				Title:   "Everything is Wonderful",
+				DOI:     "10.123/wonderful",
+				Journal: "Letters in the Alphabet",
+				ISSN:    "1234-5678",
+			},
+		},
+		{
+			about:  "existing values are kept",
+			header: &GrobidBiblio{Title: "Everything is Wonderful", DOI: "10.123/header"},
+			citations: []*GrobidBiblio{
+				{Title: "Everything is Wonderful", DOI: "10.123/wonderful", Journal: "Letters"},
+			},
+			result: &GrobidBiblio{Title: "Everything is Wonderful", DOI: "10.123/header", Journal: "Letters"},
+		},
+		{
+			about:  "dissimilar titles do not match",
+			header: &GrobidBiblio{Title: "Everything is Wonderful"},
+			citations: []*GrobidBiblio{
+				{Title: "Everything is Terrible", DOI: "10.123/terrible"},
+			},
+			result: &GrobidBiblio{Title: "Everything is Wonderful"},
+		},
+	}
+	for _, c := range cases {
+		doc := &GrobidDocument{Header: c.header, Citations: c.citations}
+		doc.EnrichHeader()
+		if !reflect.DeepEqual(doc.Header, c.result) {
+			t.Fatalf("[%s] got %+v, want %+v", c.about, doc.Header, c.result)
+		}
+	}
+}
+
+func TestParsePersNames(t *testing.T) {
+	var data = `<persName xmlns="http://www.tei-c.org/ns/1.0"><forename type="first">John</forename><forename type="middle">F</forename><surname>Doe</surname></persName>
+<persName xmlns="http://www.tei-c.org/ns/1.0"><forename type="first">Jane</forename><surname>Roe</surname></persName>`
+	authors := ParsePersNames(data)
+	want := []*GrobidAuthor{
+		{FullName: "John F Doe", GivenName: "John", MiddleName: "F", Surname: "Doe"},
+		{FullName: "Jane Roe", GivenName: "Jane", Surname: "Roe"},
+	}
+	if !reflect.DeepEqual(authors, want) {
+		b, _ := json.Marshal(authors)
+		t.Fatalf("got %s", b)
+	}
+	if authors := ParsePersNames(""); authors != nil {
+		t.Fatalf("got %v, want nil", authors)
+	}
+}
+
+func TestArxivVersion(t *testing.T) {
+	var cases = []struct {
+		about   string
+		idno    string
+		arxivID string
+		version string
+	}{
+		{"new style, versioned", "2101.00001v2", "2101.00001", "v2"},
+		{"new style with prefix", "arXiv:2101.00001v12", "2101.00001", "v12"},
+		{"old style, versioned", "hep-th/9901001v1", "hep-th/9901001", "v1"},
+		{"unversioned", "2101.00001", "2101.00001", ""},
+	}
+	for _, c := range cases {
+		data := fmt.Sprintf(`<biblStruct><analytic><title level="a" type="main">Preprint</title>
+<idno type="arXiv">%s</idno></analytic><monogr><edition>Second preprint</edition></monogr></biblStruct>`, c.idno)
+		doc := ParseCitation(data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.ArxivID != c.arxivID || doc.Version != c.version {
+			t.Fatalf("[%s] got %q %q, want %q %q", c.about, doc.ArxivID, doc.Version, c.arxivID, c.version)
+		}
+		if want := "Second preprint"; doc.Edition != want {
+			t.Fatalf("[%s] got %v, want %v", c.about, doc.Edition, want)
+		}
+	}
+}
+
+func TestOpenAccess(t *testing.T) {
+	var cases = []struct {
+		about      string
+		data       string
+		openAccess bool
+		oaURL      string
+		url        string
+	}{
+		{
+			about: "open access link",
+			data: `<biblStruct><analytic><title level="a" type="main">Free Text</title></analytic>
+<monogr><imprint><date type="published" when="2020" /></imprint></monogr>
+<ptr target="https://example.com/landing" />
+<ptr type="open-access" target="https://example.com/free.pdf" /></biblStruct>`,
+			openAccess: true,
+			oaURL:      "https://example.com/free.pdf",
+			url:        "https://example.com/landing",
+		},
+		{
+			about: "open access link only",
+			data: `<biblStruct><analytic><title level="a" type="main">Free Text</title></analytic>
+<ptr type="open-access" target="https://example.com/free.pdf" /></biblStruct>`,
+			openAccess: true,
+			oaURL:      "https://example.com/free.pdf",
+			url:        "https://example.com/free.pdf",
+		},
+		{
+			about: "open access idno",
+			data: `<biblStruct><analytic><title level="a" type="main">Free Text</title>
+<idno type="OA">true</idno></analytic></biblStruct>`,
+			openAccess: true,
+		},
+		{
+			about:      "closed",
+			data:       `<biblStruct><analytic><title level="a" type="main">Paywalled</title></analytic></biblStruct>`,
+			openAccess: false,
+		},
+	}
+	for _, c := range cases {
+		doc := ParseCitation(c.data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.OpenAccess != c.openAccess || doc.OAURL != c.oaURL || doc.URL != c.url {
+			t.Fatalf("[%s] got %v %q %q, want %v %q %q", c.about,
+				doc.OpenAccess, doc.OAURL, doc.URL, c.openAccess, c.oaURL, c.url)
+		}
+	}
+}
+
+func TestTechReport(t *testing.T) {
+	var data = `
+<biblStruct xml:id="b12">
+    <monogr>
+        <title level="m">Algorithms for Clustering Data</title>
+        <author>
+            <persName><forename type="first">Anil</forename><surname>Jain</surname></persName>
+        </author>
+        <respStmt>
+            <orgName>Michigan State University</orgName>
+        </respStmt>
+        <idno type="report">MSU-CPS-88-4</idno>
+        <imprint>
+            <biblScope unit="chapter">5</biblScope>
+            <date type="published" when="1988" />
+        </imprint>
+    </monogr>
+    <note type="report_type">Technical Report</note>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatal("expected non nil result")
+	}
+	if want := "MSU-CPS-88-4"; doc.ReportNumber != want {
+		t.Fatalf("got %v, want %v", doc.ReportNumber, want)
+	}
+	if want := "5"; doc.Chapter != want {
+		t.Fatalf("got %v, want %v", doc.Chapter, want)
+	}
+	if want := "Michigan State University"; doc.Institution != want {
+		t.Fatalf("got %v, want %v", doc.Institution, want)
+	}
+	if want := "Algorithms for Clustering Data"; doc.Title != want {
+		t.Fatalf("got %v, want %v", doc.Title, want)
+	}
+}
+
+func TestBookChapterEditors(t *testing.T) {
+	var data = `
+<biblStruct xml:id="b7">
+    <analytic>
+        <title level="a" type="main">Chapter on Rivers</title>
+        <author>
+            <persName><forename type="first">Ada</forename><surname>Lovelace</surname></persName>
         </author>
+        <editor>Not A Volume Editor</editor>
+    </analytic>
+    <monogr>
+        <title level="m">Handbook of Water</title>
+        <editor>
+            <persName><forename type="first">Charles</forename><surname>Babbage</surname></persName>
+        </editor>
+        <editor>
+            <persName><forename type="first">Mary</forename><surname>Somerville</surname></persName>
+        </editor>
+        <imprint>
+            <date type="published" when="1843" />
+        </imprint>
+    </monogr>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatal("expected non nil result")
+	}
+	var authors, editors []string
+	for _, a := range doc.Authors {
+		authors = append(authors, a.Surname)
+	}
+	for _, e := range doc.Editors {
+		editors = append(editors, e.Surname)
+	}
+	if want := []string{"Lovelace"}; !reflect.DeepEqual(authors, want) {
+		t.Fatalf("authors: got %v, want %v", authors, want)
+	}
+	if want := []string{"Babbage", "Somerville"}; !reflect.DeepEqual(editors, want) {
+		t.Fatalf("editors: got %v, want %v", editors, want)
+	}
+	if want := "Handbook of Water"; doc.BookTitle != want {
+		t.Fatalf("got %v, want %v", doc.BookTitle, want)
+	}
+}
+
+func TestMultipleAffiliations(t *testing.T) {
+	var data = `
+<biblStruct>
+    <analytic>
+        <title level="a" type="main">On Two Places at Once</title>
         <author>
-            <persName
-                xmlns="http://www.tei-c.org/ns/1.0">
-                <forename type="first">L</forename>
-                <forename type="middle">R</forename>
-                <surname>Taveras</surname>
-            </persName>
+            <persName><forename type="first">Marie</forename><surname>Curie</surname></persName>
+            <affiliation key="aff0">
+                <orgName type="institution">Sorbonne</orgName>
+                <orgName type="institution">Institut du Radium</orgName>
+                <address><country>France</country></address>
+            </affiliation>
+            <affiliation key="aff1">
+                <orgName type="department">Physics</orgName>
+                <orgName type="institution">University of Warsaw</orgName>
+            </affiliation>
         </author>
+    </analytic>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatal("expected non nil result")
+	}
+	if len(doc.Authors) != 1 {
+		t.Fatalf("got %d authors, want 1", len(doc.Authors))
+	}
+	author := doc.Authors[0]
+	if len(author.Affiliations) != 2 {
+		t.Fatalf("got %d affiliations, want 2", len(author.Affiliations))
+	}
+	if author.Affiliation != author.Affiliations[0] {
+		t.Fatalf("expected first affiliation to be the primary affiliation")
+	}
+	if want := "Sorbonne"; author.Affiliation.Institution != want {
+		t.Fatalf("got %v, want %v", author.Affiliation.Institution, want)
+	}
+	if want := []string{"Sorbonne", "Institut du Radium"}; !reflect.DeepEqual(author.Affiliations[0].Institutions, want) {
+		t.Fatalf("got %v, want %v", author.Affiliations[0].Institutions, want)
+	}
+	if want := []string{"University of Warsaw"}; !reflect.DeepEqual(author.Affiliations[1].Institutions, want) {
+		t.Fatalf("got %v, want %v", author.Affiliations[1].Institutions, want)
+	}
+	if want := "Physics"; author.Affiliations[1].Department != want {
+		t.Fatalf("got %v, want %v", author.Affiliations[1].Department, want)
+	}
+}
+
+func TestAffiliationAddress(t *testing.T) {
+	var data = `
+<biblStruct>
+    <analytic>
         <author>
-            <persName
-                xmlns="http://www.tei-c.org/ns/1.0">
-                <forename type="first">S</forename>
-                <surname>Huerta</surname>
-            </persName>
+            <persName><forename type="first">Grace</forename><surname>Hopper</surname></persName>
+            <affiliation key="aff0">
+                <orgName type="institution">Yale University</orgName>
+                <address>
+                    <addrLine>New Haven</addrLine>
+                    <settlement>New Haven</settlement>
+                    <region>Connecticut</region>
+                    <country key="US">USA</country>
+                    <geo>41.3163 -72.9223</geo>
+                </address>
+            </affiliation>
         </author>
-        <idno type="DOI">10.1007/s10029-019-01898-9</idno>
-        <idno type="PMID">30701369</idno>
     </analytic>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil || len(doc.Authors) != 1 || doc.Authors[0].Affiliation == nil {
+		t.Fatal("expected author with affiliation")
+	}
+	want := &GrobidAddress{
+		AddrLine:    "New Haven",
+		Settlement:  "New Haven",
+		Region:      "Connecticut",
+		Country:     "USA",
+		Coordinates: "41.3163 -72.9223",
+	}
+	if got := doc.Authors[0].Affiliation.Address; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRawAffiliation(t *testing.T) {
+	var cases = []struct {
+		about string
+		aff   string
+		want  *GrobidAffiliation
+	}{
+		{
+			about: "parsed and raw",
+			aff: `<note type="raw_affiliation"><label>1</label> Department of Physics, MIT, Cambridge, MA</note>
+<orgName type="department">Department of Physics</orgName>
+<orgName type="institution">MIT</orgName>`,
+			want: &GrobidAffiliation{
+				Institution:    "MIT",
+				Institutions:   []string{"MIT"},
+				Department:     "Department of Physics",
+				RawAffiliation: "Department of Physics, MIT, Cambridge, MA",
+			},
+		},
+		{
+			about: "raw only",
+			aff:   `<note type="raw_affiliation">Somewhere, Earth</note>`,
+			want:  &GrobidAffiliation{RawAffiliation: "Somewhere, Earth"},
+		},
+	}
+	for _, c := range cases {
+		data := fmt.Sprintf(`<biblStruct><analytic><author>
+<persName><forename type="first">Ada</forename><surname>Lovelace</surname></persName>
+<affiliation key="aff0">%s</affiliation></author></analytic></biblStruct>`, c.aff)
+		doc := ParseCitation(data)
+		if doc == nil || len(doc.Authors) != 1 {
+			t.Fatalf("[%s] expected a single author", c.about)
+		}
+		if got := doc.Authors[0].Affiliation; !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("[%s] got %+v, want %+v", c.about, got, c.want)
+		}
+	}
+}
+
+func TestContributors(t *testing.T) {
+	var data = `
+<biblStruct>
     <monogr>
-        <title level="j">Hernia</title>
-        <imprint>
-            <biblScope unit="volume">23</biblScope>
-            <biblScope unit="issue">2</biblScope>
-            <biblScope unit="page" from="235" to="243" />
-            <date type="published" when="2019-01-30" />
-        </imprint>
+        <title level="m">Don Quixote</title>
+        <author><persName><forename type="first">Miguel</forename><surname>de Cervantes</surname></persName></author>
+        <respStmt>
+            <resp>translator</resp>
+            <persName><forename type="first">Edith</forename><surname>Grossman</surname></persName>
+        </respStmt>
+        <respStmt>
+            <resp>illustrated by</resp>
+            <persName>Gustave Doré</persName>
+        </respStmt>
+        <respStmt>
+            <orgName>Ecco Press</orgName>
+        </respStmt>
     </monogr>
-</biblStruct>"
-	`
+</biblStruct>`
 	doc := ParseCitation(data)
 	if doc == nil {
 		t.Fatal("expected non nil result")
 	}
-	if doc.IsEmpty() != false {
-		t.Fatal("empty: want false")
+	want := []*GrobidContributor{
+		{Role: "translator", Person: &GrobidAuthor{FullName: "Edith Grossman", GivenName: "Edith", Surname: "Grossman"}},
+		{Role: "illustrated by", Person: &GrobidAuthor{FullName: "Gustave Doré"}},
 	}
-	if want := `Mesh migration following abdominal hernia repair: a comprehensive review`; doc.Title != want {
-		t.Fatalf("got %s, want %v", doc.Title, want)
+	if !reflect.DeepEqual(doc.Contributors, want) {
+		t.Fatalf("got %v, want %v", doc.Contributors, want)
 	}
-	if len(doc.Authors) < 3 {
-		t.Fatalf("expeted at least 3 authors")
+	if len(doc.Authors) != 1 {
+		t.Fatalf("got %d authors, want 1", len(doc.Authors))
 	}
-	if want := `L`; doc.Authors[2].GivenName != want {
-		t.Fatalf("got %v, want %v", doc.Authors[2].GivenName, want)
+	if want := "Ecco Press"; doc.Institution != want {
+		t.Fatalf("got %v, want %v", doc.Institution, want)
 	}
-	if want := `R`; doc.Authors[2].MiddleName != want {
-		t.Fatalf("got %v, want %v", doc.Authors[2].MiddleName, want)
+}
+
+func TestVolumeIssue(t *testing.T) {
+	var cases = []struct {
+		about         string
+		imprint       string
+		volume, issue string
+	}{
+		{
+			about:   "plain",
+			imprint: `<biblScope unit="volume">12</biblScope><biblScope unit="issue">3</biblScope>`,
+			volume:  "12",
+			issue:   "3",
+		},
+		{
+			about:   "supplement issue",
+			imprint: `<biblScope unit="volume">12</biblScope><biblScope unit="issue">Suppl 2</biblScope>`,
+			volume:  "12",
+			issue:   "Suppl 2",
+		},
+		{
+			about:   "volume range",
+			imprint: `<biblScope unit="volume" from="1" to="3"/>`,
+			volume:  "1-3",
+		},
+		{
+			about:   "issue range, from only",
+			imprint: `<biblScope unit="issue" from="4"/>`,
+			issue:   "4",
+		},
+		{
+			about:   "range with equal bounds",
+			imprint: `<biblScope unit="volume" from="7" to="7"/>`,
+			volume:  "7",
+		},
 	}
-	if want := `Taveras`; doc.Authors[2].Surname != want {
-		t.Fatalf("got %v, want %v", doc.Authors[2].Surname, want)
+	for _, c := range cases {
+		data := `<biblStruct><monogr><title level="j">J</title><imprint>` + c.imprint + `</imprint></monogr></biblStruct>`
+		doc := ParseCitation(data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.Volume != c.volume || doc.Issue != c.issue {
+			t.Fatalf("[%s] got %q, %q, want %q, %q", c.about, doc.Volume, doc.Issue, c.volume, c.issue)
+		}
 	}
-	if want := `L R Taveras`; doc.Authors[2].FullName != want {
-		t.Fatalf("got %v, want %v", doc.Authors[2].FullName, want)
+}
+
+func TestContainerDOI(t *testing.T) {
+	var cases = []struct {
+		about             string
+		data              string
+		doi, containerDOI string
+	}{
+		{
+			about: "article and journal DOI, journal first",
+			data: `<biblStruct>
+    <monogr><title level="j">Journal</title><idno type="DOI">10.1234/JOURNAL</idno></monogr>
+    <analytic><title level="a" type="main">Article</title><idno type="DOI">10.1234/article.1</idno></analytic>
+</biblStruct>`,
+			doi:          "10.1234/article.1",
+			containerDOI: "10.1234/journal",
+		},
+		{
+			about: "book chapter without analytic DOI",
+			data: `<biblStruct>
+    <analytic><title level="a" type="main">Chapter</title></analytic>
+    <monogr><title level="m">Book</title><idno type="DOI">10.1234/book</idno></monogr>
+</biblStruct>`,
+			containerDOI: "10.1234/book",
+		},
+		{
+			about: "book",
+			data: `<biblStruct>
+    <monogr><title level="m">Book</title><idno type="DOI">10.1234/book</idno></monogr>
+</biblStruct>`,
+			doi: "10.1234/book",
+		},
+		{
+			about: "DOI outside of analytic and monogr",
+			data: `<biblStruct>
+    <analytic><title level="a" type="main">Article</title></analytic>
+    <monogr><title level="j">Journal</title><idno type="DOI">10.1234/journal</idno></monogr>
+    <idno type="DOI">10.1234/article.1</idno>
+</biblStruct>`,
+			doi:          "10.1234/article.1",
+			containerDOI: "10.1234/journal",
+		},
 	}
-	if want := `10.1007/s10029-019-01898-9`; doc.DOI != want {
-		t.Fatalf("got %v, want %v", doc.DOI, want)
+	for _, c := range cases {
+		doc := ParseCitation(c.data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.DOI != c.doi || doc.ContainerDOI != c.containerDOI {
+			t.Fatalf("[%s] got %q, %q, want %q, %q", c.about, doc.DOI, doc.ContainerDOI, c.doi, c.containerDOI)
+		}
 	}
-	if want := `30701369`; doc.PMID != want {
-		t.Fatalf("got %v, want %v", doc.PMID, want)
+}
+
+func TestOtherIDs(t *testing.T) {
+	var data = `<biblStruct>
+    <analytic>
+        <title level="a" type="main">Article</title>
+        <author><persName><surname>Doe</surname></persName><idno type="ORCID">0000-0002-1825-0097</idno></author>
+        <idno type="DOI">10.1234/article.1</idno>
+        <idno type="DOI">10.1234/ARTICLE.1.V2</idno>
+        <idno type="PMID">30701369</idno>
+    </analytic>
+    <monogr>
+        <title level="j">Journal</title>
+        <idno type="ISSN">1265-4906</idno>
+        <idno type="DOI">10.1234/journal</idno>
+    </monogr>
+    <idno type="wikidata">Q1</idno>
+    <idno type="oai"> oai:arXiv.org:1901.00001 </idno>
+    <idno type="oai">oai:europepmc.org:123</idno>
+    <idno>local-1</idno>
+    <idno type="hal"></idno>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatalf("expected non nil result")
 	}
-	if want := `2019-01-30`; doc.Date != want {
-		t.Fatalf("got %v, want %v", doc.Date, want)
+	want := map[string][]string{
+		"":         {"local-1"},
+		"DOI":      {"10.1234/article.1", "10.1234/ARTICLE.1.V2", "10.1234/journal"},
+		"ISSN":     {"1265-4906"},
+		"PMID":     {"30701369"},
+		"oai":      {"oai:arXiv.org:1901.00001", "oai:europepmc.org:123"},
+		"wikidata": {"Q1"},
 	}
-	if want := `235-243`; doc.Pages != want {
-		t.Fatalf("got %v, want %v", doc.Pages, want)
+	if !reflect.DeepEqual(doc.OtherIDs, want) {
+		t.Fatalf("got %v, want %v", doc.OtherIDs, want)
 	}
-	if want := `235`; doc.FirstPage != want {
-		t.Fatalf("got %v, want %v", doc.FirstPage, want)
+	if doc.DOI != "10.1234/article.1" || doc.ContainerDOI != "10.1234/journal" || doc.PMID != "30701369" {
+		t.Fatalf("got %q, %q, %q, typed fields changed", doc.DOI, doc.ContainerDOI, doc.PMID)
 	}
-	if want := `243`; doc.LastPage != want {
-		t.Fatalf("got %v, want %v", doc.LastPage, want)
+	doc = ParseCitation(`<biblStruct><analytic><title level="a" type="main">No identifiers</title></analytic></biblStruct>`)
+	if doc.OtherIDs != nil {
+		t.Fatalf("got %v, want nil", doc.OtherIDs)
 	}
-	if want := `2`; doc.Issue != want {
-		t.Fatalf("got %v, want %v", doc.Issue, want)
+}
+
+func TestPubPlace(t *testing.T) {
+	var cases = []struct {
+		about     string
+		data      string
+		publisher string
+		pubPlace  string
+	}{
+		{
+			about: "book with imprint",
+			data: `<biblStruct>
+    <monogr>
+        <title level="m" type="main">Handbook of Optics</title>
+        <imprint>
+            <publisher>McGraw-Hill</publisher>
+            <pubPlace>New York</pubPlace>
+            <date type="published" when="2010" />
+        </imprint>
+    </monogr>
+</biblStruct>`,
+			publisher: "McGraw-Hill",
+			pubPlace:  "New York",
+		},
+		{
+			about: "publication statement",
+			data: `<biblStruct>
+    <monogr><title level="m" type="main">Handbook of Optics</title></monogr>
+    <publicationStmt><publisher>McGraw-Hill</publisher><pubPlace>London</pubPlace></publicationStmt>
+</biblStruct>`,
+			publisher: "McGraw-Hill",
+			pubPlace:  "London",
+		},
+		{
+			about: "no place",
+			data: `<biblStruct>
+    <monogr><title level="m" type="main">Handbook of Optics</title><imprint><publisher>McGraw-Hill</publisher></imprint></monogr>
+</biblStruct>`,
+			publisher: "McGraw-Hill",
+		},
 	}
-	if want := `Hernia`; doc.Journal != want {
-		t.Fatalf("got %v, want %v", doc.Journal, want)
+	for _, c := range cases {
+		doc := ParseCitation(c.data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.Publisher != c.publisher || doc.PubPlace != c.pubPlace {
+			t.Fatalf("[%s] got %q, %q, want %q, %q", c.about, doc.Publisher, doc.PubPlace, c.publisher, c.pubPlace)
+		}
+	}
+}
+
+func TestAllContributors(t *testing.T) {
+	var (
+		doe     = &GrobidAuthor{FullName: "Jane Doe", GivenName: "Jane", Surname: "Doe"}
+		roe     = &GrobidAuthor{GivenName: "Richard", Surname: "Roe"}
+		smith   = &GrobidAuthor{FullName: "A Smith"}
+		unnamed = &GrobidAuthor{Email: "x@example.com"}
+	)
+	biblio := &GrobidBiblio{
+		Authors: []*GrobidAuthor{doe, roe, {FullName: "jane  doe"}, unnamed, unnamed},
+		Editors: []*GrobidAuthor{doe, smith},
+		Contributors: []*GrobidContributor{
+			{Role: "translator", Person: roe},
+			{Person: smith},
+			{Role: "illustrator"},
+		},
+	}
+	want := []Contributor{
+		{Role: "author", Sequence: 0, Person: doe},
+		{Role: "author", Sequence: 1, Person: roe},
+		{Role: "author", Sequence: 2, Person: unnamed},
+		{Role: "author", Sequence: 3, Person: unnamed},
+		{Role: "editor", Sequence: 0, Person: doe},
+		{Role: "editor", Sequence: 1, Person: smith},
+		{Role: "translator", Sequence: 0, Person: roe},
+		{Role: "contributor", Sequence: 0, Person: smith},
+	}
+	if got := biblio.AllContributors(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := (&GrobidBiblio{}).AllContributors(); got != nil {
+		t.Fatalf("got %v, want nil", got)
+	}
+}
+
+func TestTitleLang(t *testing.T) {
+	f, err := os.Open("../testdata/document/multilingual.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	defer f.Close()
+	doc, err := ParseDocument(f)
+	if err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	var (
+		h    = doc.Header
+		want = []string{"深層学習による文書構造解析", "ja", "Document Structure Analysis with Deep Learning"}
+	)
+	if got := []string{h.Title, h.TitleLang, h.AltTitle}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	var cases = []struct {
+		about     string
+		data      string
+		titleLang string
+		altTitle  string
+	}{
+		{
+			about:     "single title",
+			data:      `<biblStruct><analytic><title level="a" type="main" xml:lang="en">Title</title></analytic></biblStruct>`,
+			titleLang: "en",
+		},
+		{
+			about: "same language and subtitle",
+			data: `<biblStruct><analytic>
+    <title level="a" type="main" xml:lang="en">Title</title>
+    <title level="a" type="sub" xml:lang="de">Untertitel</title>
+    <title level="a" xml:lang="en">Another Title</title>
+</analytic></biblStruct>`,
+			titleLang: "en",
+		},
+		{
+			about:    "alternative title",
+			data:     `<biblStruct><analytic><title level="a" type="main">Titel</title><title level="a" type="alt">Title</title></analytic></biblStruct>`,
+			altTitle: "Title",
+		},
+	}
+	for _, c := range cases {
+		doc := ParseCitation(c.data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.TitleLang != c.titleLang || doc.AltTitle != c.altTitle {
+			t.Fatalf("[%s] got %q, %q, want %q, %q", c.about, doc.TitleLang, doc.AltTitle, c.titleLang, c.altTitle)
+		}
+	}
+}
+
+func TestPageCount(t *testing.T) {
+	var cases = []struct {
+		about     string
+		data      string
+		pageCount int
+		pages     string
+	}{
+		{
+			about: "book with extent measure",
+			data: `<biblStruct>
+    <monogr>
+        <title level="m" type="main">Handbook of Optics</title>
+        <imprint><publisher>McGraw-Hill</publisher><date type="published" when="2010" /></imprint>
+        <extent><measure unit="pages" quantity="352"/></extent>
+    </monogr>
+</biblStruct>`,
+			pageCount: 352,
+		},
+		{
+			about: "extent as text",
+			data: `<biblStruct><monogr><title level="m" type="main">Handbook of Optics</title>
+    <extent>xii, 1104 p.</extent></monogr></biblStruct>`,
+			pageCount: 1104,
+		},
+		{
+			about: "biblScope pp",
+			data: `<biblStruct><monogr><title level="m" type="main">Report</title>
+    <imprint><biblScope unit="pp">48</biblScope></imprint></monogr></biblStruct>`,
+			pageCount: 48,
+		},
+		{
+			about: "page range only",
+			data: `<biblStruct><monogr><title level="j">Hernia</title>
+    <imprint><biblScope unit="page" from="235" to="243" /></imprint></monogr></biblStruct>`,
+			pages: "235-243",
+		},
+	}
+	for _, c := range cases {
+		doc := ParseCitation(c.data)
+		if doc == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		if doc.PageCount != c.pageCount || doc.Pages != c.pages {
+			t.Fatalf("[%s] got %d, %q, want %d, %q", c.about, doc.PageCount, doc.Pages, c.pageCount, c.pages)
+		}
+	}
+}
+
+func TestSubtitle(t *testing.T) {
+	var data = `
+<biblStruct>
+    <monogr>
+        <title level="m" type="main">Gödel, Escher, Bach</title>
+        <title level="m" type="sub">An Eternal Golden Braid</title>
+        <author>
+            <persName>
+                <forename type="first">Douglas</forename>
+                <surname>Hofstadter</surname>
+            </persName>
+        </author>
+        <imprint>
+            <publisher>Basic Books</publisher>
+            <date type="published" when="1979" />
+        </imprint>
+    </monogr>
+</biblStruct>`
+	doc := ParseCitation(data)
+	if doc == nil {
+		t.Fatal("expected non nil result")
+	}
+	if want := "Gödel, Escher, Bach"; doc.Title != want {
+		t.Fatalf("got %v, want %v", doc.Title, want)
+	}
+	if want := "An Eternal Golden Braid"; doc.Subtitle != want {
+		t.Fatalf("got %v, want %v", doc.Subtitle, want)
+	}
+	if want := "Gödel, Escher, Bach: An Eternal Golden Braid"; doc.TitleFull() != want {
+		t.Fatalf("got %v, want %v", doc.TitleFull(), want)
+	}
+	doc.Subtitle = ""
+	if want := "Gödel, Escher, Bach"; doc.TitleFull() != want {
+		t.Fatalf("got %v, want %v", doc.TitleFull(), want)
 	}
 }
 
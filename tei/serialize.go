@@ -0,0 +1,298 @@
+package tei
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/beevik/etree"
+)
+
+// TEI renders the bibliographic record as a minimal TEI <biblStruct>
+// element. The output is not byte-identical to what GROBID would produce, but
+// parsing it with ParseCitation results in an equivalent struct, if OtherIDs
+// contains the typed identifiers, as ParseCitation returns it.
+func (b *GrobidBiblio) TEI() (string, error) {
+	doc := etree.NewDocument()
+	bs := doc.CreateElement("biblStruct")
+	bs.CreateAttr("xmlns", NS)
+	if b.ID != "" {
+		bs.CreateAttr("xml:id", b.ID)
+	}
+	if b.PatentNumber != "" {
+		bs.CreateAttr("type", "patent")
+	}
+	analytic := bs.CreateElement("analytic")
+	if b.Title != "" {
+		el := createTitle(analytic, "a", "main", b.Title)
+		if b.TitleLang != "" {
+			el.CreateAttr("xml:lang", b.TitleLang)
+		}
+	}
+	if b.AltTitle != "" {
+		createTitle(analytic, "a", "alt", b.AltTitle)
+	}
+	if b.Subtitle != "" {
+		createTitle(analytic, "a", "sub", b.Subtitle)
+	}
+	for _, a := range b.Authors {
+		writeAuthor(analytic.CreateElement("author"), a)
+	}
+	createIdno(analytic, "DOI", b.DOI)
+	createIdno(analytic, "PMID", b.PMID)
+	createIdno(analytic, "PMCID", b.PMCID)
+	if b.ArxivID != "" {
+		createIdno(analytic, "arXiv", b.ArxivID+b.Version)
+	}
+	createIdno(analytic, "PII", b.PII)
+	createIdno(analytic, "ark", b.Ark)
+	createIdno(analytic, "istexId", b.IsTexID)
+	monogr := bs.CreateElement("monogr")
+	if b.Journal != "" {
+		createTitle(monogr, "j", "", b.Journal)
+	}
+	if b.JournalAbbrev != "" {
+		createTitle(monogr, "j", "abbrev", b.JournalAbbrev)
+	}
+	if b.BookTitle != "" {
+		createTitle(monogr, "m", "", b.BookTitle)
+	}
+	if b.SeriesTitle != "" {
+		createTitle(monogr, "s", "", b.SeriesTitle)
+	}
+	for _, e := range b.Editors {
+		writePersName(monogr.CreateElement("editor"), e)
+	}
+	if b.Edition != "" {
+		monogr.CreateElement("edition").SetText(b.Edition)
+	}
+	if b.Institution != "" {
+		monogr.CreateElement("respStmt").CreateElement("orgName").SetText(b.Institution)
+	}
+	for _, c := range b.Contributors {
+		rs := monogr.CreateElement("respStmt")
+		if c.Role != "" {
+			rs.CreateElement("resp").SetText(c.Role)
+		}
+		if c.Person != nil {
+			writePersName(rs, c.Person)
+		}
+	}
+	createIdno(monogr, "DOI", b.ContainerDOI)
+	createIdno(monogr, "ISSN", b.ISSN)
+	createIdno(monogr, "eISSN", b.EISSN)
+	createIdno(monogr, "report", b.ReportNumber)
+	createIdno(monogr, "docdb", b.PatentNumber)
+	imprint := monogr.CreateElement("imprint")
+	if b.Publisher != "" {
+		imprint.CreateElement("publisher").SetText(b.Publisher)
+	}
+	if b.PubPlace != "" {
+		imprint.CreateElement("pubPlace").SetText(b.PubPlace)
+	}
+	if b.Volume != "" {
+		createBiblScope(imprint, "volume").SetText(b.Volume)
+	}
+	if b.Issue != "" {
+		createBiblScope(imprint, "issue").SetText(b.Issue)
+	}
+	if b.Chapter != "" {
+		createBiblScope(imprint, "chapter").SetText(b.Chapter)
+	}
+	switch {
+	case b.FirstPage != "" || b.LastPage != "":
+		el := createBiblScope(imprint, "page")
+		if b.FirstPage != "" {
+			el.CreateAttr("from", b.FirstPage)
+		}
+		if b.LastPage != "" {
+			el.CreateAttr("to", b.LastPage)
+		}
+	case b.Pages != "":
+		createBiblScope(imprint, "page").SetText(b.Pages)
+	}
+	if b.Date != "" {
+		el := imprint.CreateElement("date")
+		el.CreateAttr("type", "published")
+		el.CreateAttr("when", b.Date)
+	}
+	if b.PageCount > 0 {
+		el := monogr.CreateElement("extent").CreateElement("measure")
+		el.CreateAttr("unit", "pages")
+		el.CreateAttr("quantity", strconv.Itoa(b.PageCount))
+	}
+	// The untyped note needs to come first, as it is looked up as the first
+	// note of the record.
+	if b.Note != "" {
+		bs.CreateElement("note").SetText(b.Note)
+	}
+	if b.Unstructured != "" {
+		el := bs.CreateElement("note")
+		el.CreateAttr("type", "raw_reference")
+		el.SetText(b.Unstructured)
+	}
+	if b.URL != "" && b.URL != b.OAURL {
+		// An open access link, written below, is the URL as well.
+		bs.CreateElement("ptr").CreateAttr("target", b.URL)
+	}
+	switch {
+	case b.OAURL != "":
+		el := bs.CreateElement("ptr")
+		el.CreateAttr("type", "open-access")
+		el.CreateAttr("target", b.OAURL)
+	case b.OpenAccess:
+		createIdno(bs, "OA", "true")
+	}
+	// Other identifiers follow the typed ones of the same type, so they are
+	// not mistaken for them when parsing. OtherIDs includes the typed
+	// identifiers, which are already written.
+	written := make(map[[2]string]int)
+	for _, parent := range []*etree.Element{analytic, monogr, bs} {
+		for _, el := range parent.SelectElements("idno") {
+			written[[2]string{el.SelectAttrValue("type", ""), el.Text()}]++
+		}
+	}
+	types := make([]string, 0, len(b.OtherIDs))
+	for typ := range b.OtherIDs {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		for _, v := range b.OtherIDs[typ] {
+			if k := [2]string{typ, v}; written[k] > 0 {
+				written[k]--
+				continue
+			}
+			parent := bs
+			switch typ {
+			case "DOI", "PMID", "PMCID", "arXiv", "PII", "ark", "istexId":
+				parent = analytic
+			case "ISSN", "eISSN", "report", "docdb":
+				parent = monogr
+			}
+			el := parent.CreateElement("idno")
+			if typ != "" {
+				el.CreateAttr("type", typ)
+			}
+			el.SetText(v)
+		}
+	}
+	doc.Indent(2)
+	return doc.WriteToString()
+}
+
+// createTitle adds a title element with a given level and optional type.
+func createTitle(parent *etree.Element, level, typ, text string) *etree.Element {
+	el := parent.CreateElement("title")
+	el.CreateAttr("level", level)
+	if typ != "" {
+		el.CreateAttr("type", typ)
+	}
+	el.SetText(text)
+	return el
+}
+
+// createIdno adds an idno element of a given type, if the value is not empty.
+func createIdno(parent *etree.Element, typ, value string) {
+	if value == "" {
+		return
+	}
+	el := parent.CreateElement("idno")
+	el.CreateAttr("type", typ)
+	el.SetText(value)
+}
+
+// createBiblScope adds a biblScope element with a given unit.
+func createBiblScope(parent *etree.Element, unit string) *etree.Element {
+	el := parent.CreateElement("biblScope")
+	el.CreateAttr("unit", unit)
+	return el
+}
+
+// writeAuthor renders author information into an <author> element.
+func writeAuthor(elem *etree.Element, a *GrobidAuthor) {
+	if a.Corresponding {
+		elem.CreateAttr("role", "corresp")
+	}
+	writePersName(elem, a)
+	if a.Email != "" {
+		elem.CreateElement("email").SetText(a.Email)
+	}
+	if a.ORCID != "" {
+		createIdno(elem, "ORCID", a.ORCID)
+	}
+	affs := a.Affiliations
+	if len(affs) == 0 && a.Affiliation != nil {
+		affs = []*GrobidAffiliation{a.Affiliation}
+	}
+	for _, aff := range affs {
+		writeAffiliation(elem.CreateElement("affiliation"), aff)
+	}
+}
+
+// writePersName adds a persName element to elem. If no name parts are known,
+// the full name is used as text.
+func writePersName(elem *etree.Element, a *GrobidAuthor) {
+	pn := elem.CreateElement("persName")
+	if a.GivenName == "" && a.MiddleName == "" && a.Surname == "" {
+		pn.SetText(a.FullName)
+		return
+	}
+	if a.GivenName != "" {
+		el := pn.CreateElement("forename")
+		el.CreateAttr("type", "first")
+		el.SetText(a.GivenName)
+	}
+	if a.MiddleName != "" {
+		el := pn.CreateElement("forename")
+		el.CreateAttr("type", "middle")
+		el.SetText(a.MiddleName)
+	}
+	if a.Surname != "" {
+		pn.CreateElement("surname").SetText(a.Surname)
+	}
+}
+
+// writeAffiliation renders an affiliation into an <affiliation> element.
+func writeAffiliation(elem *etree.Element, aff *GrobidAffiliation) {
+	institutions := aff.Institutions
+	if len(institutions) == 0 && aff.Institution != "" {
+		institutions = []string{aff.Institution}
+	}
+	for _, v := range institutions {
+		el := elem.CreateElement("orgName")
+		el.CreateAttr("type", "institution")
+		el.SetText(v)
+	}
+	for _, v := range []struct{ typ, value string }{
+		{"department", aff.Department},
+		{"laboratory", aff.Laboratory},
+	} {
+		if v.value == "" {
+			continue
+		}
+		el := elem.CreateElement("orgName")
+		el.CreateAttr("type", v.typ)
+		el.SetText(v.value)
+	}
+	if aff.RawAffiliation != "" {
+		el := elem.CreateElement("note")
+		el.CreateAttr("type", "raw_affiliation")
+		el.SetText(aff.RawAffiliation)
+	}
+	if aff.Address == nil {
+		return
+	}
+	addr := elem.CreateElement("address")
+	for _, v := range []struct{ tag, value string }{
+		{"addrLine", aff.Address.AddrLine},
+		{"postCode", aff.Address.PostCode},
+		{"settlement", aff.Address.Settlement},
+		{"region", aff.Address.Region},
+		{"country", aff.Address.Country},
+		{"geo", aff.Address.Coordinates},
+	} {
+		if v.value != "" {
+			addr.CreateElement(v.tag).SetText(v.value)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tei
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBiblioTEIRoundTrip(t *testing.T) {
+	technion := &GrobidAffiliation{
+		Institution:    "Technion-Israel Institute of Technology",
+		Institutions:   []string{"Technion-Israel Institute of Technology"},
+		RawAffiliation: "Technion-Israel Institute of Technology, Haifa, Israel",
+		Address: &GrobidAddress{
+			Settlement: "Haifa",
+			Country:    "Israel",
+		},
+	}
+	var cases = []struct {
+		about  string
+		biblio *GrobidBiblio
+	}{
+		{
+			about: "article",
+			biblio: &GrobidBiblio{
+				Authors: []*GrobidAuthor{
+					{
+						FullName:     "H B Cunningham",
+						GivenName:    "H",
+						MiddleName:   "B",
+						Surname:      "Cunningham",
+						Email:        "hbc@example.com",
+						ORCID:        "0000-0002-1825-0097",
+						Affiliation:  technion,
+						Affiliations: []*GrobidAffiliation{technion},
+					},
+					{FullName: "Anonymous", Corresponding: true},
+				},
+				ID:           "b0",
+				Unstructured: "Cunningham HB. Mesh migration. Hernia 2019;23:235-243.",
+				Date:         "2019-01-30",
+				Title:        "Mesh migration following abdominal hernia repair",
+				Subtitle:     "a comprehensive review",
+				Journal:      "Hernia",
+				Volume:       "23",
+				Issue:        "2",
+				Pages:        "235-243",
+				FirstPage:    "235",
+				LastPage:     "243",
+				DOI:          "10.1007/s10029-019-01898-9",
+				ContainerDOI: "10.1007/10029.1265-4906",
+				PMID:         "30701369",
+				ArxivID:      "1901.00001",
+				Version:      "v3",
+				ISSN:         "1265-4906",
+				Note:         "Review",
+				OpenAccess:   true,
+				OAURL:        "https://europepmc.org/article/MED/30701369",
+				URL:          "https://europepmc.org/article/MED/30701369",
+				OtherIDs: map[string][]string{
+					"DOI":   {"10.1007/s10029-019-01898-9", "10.1007/s10029-019-01898-9.v2", "10.1007/10029.1265-4906"},
+					"ISSN":  {"1265-4906"},
+					"PMID":  {"30701369"},
+					"arXiv": {"1901.00001v3"},
+				},
+			},
+		},
+		{
+			about: "book",
+			biblio: &GrobidBiblio{
+				Editors: []*GrobidAuthor{
+					{FullName: "M Fitzmaurice", GivenName: "M", Surname: "Fitzmaurice"},
+				},
+				Date:        "2010",
+				Title:       "Devices, Measurements and Properties",
+				SeriesTitle: "Handbook of Optics",
+				Edition:     "3rd",
+				Publisher:   "McGRAW-HILL",
+				PubPlace:    "New York",
+				Pages:       "xii-xiv",
+				PageCount:   352,
+				URL:         "http://archive.org",
+				Contributors: []*GrobidContributor{
+					{Role: "translator", Person: &GrobidAuthor{FullName: "Edith Grossman", GivenName: "Edith", Surname: "Grossman"}},
+				},
+			},
+		},
+		{
+			about: "report",
+			biblio: &GrobidBiblio{
+				Date:         "1998",
+				Title:        "The Anatomy of a Search Engine",
+				TitleLang:    "en",
+				AltTitle:     "Die Anatomie einer Suchmaschine",
+				Institution:  "Stanford InfoLab",
+				ReportNumber: "1998-8",
+				Chapter:      "3",
+				OtherIDs:     map[string][]string{"": {"SIDL-WP-1999-0120"}, "report": {"1998-8"}, "wikidata": {"Q1"}},
+			},
+		},
+		{
+			about: "patent",
+			biblio: &GrobidBiblio{
+				PatentNumber: "EP2537698A1",
+				OtherIDs:     map[string][]string{"docdb": {"EP2537698A1"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		s, err := c.biblio.TEI()
+		if err != nil {
+			t.Fatalf("[%s] got %v, want nil", c.about, err)
+		}
+		parsed := ParseCitation(s)
+		if parsed == nil {
+			t.Fatalf("[%s] expected non nil result", c.about)
+		}
+		parsed.Index = c.biblio.Index
+		if !reflect.DeepEqual(parsed, c.biblio) {
+			got, _ := json.Marshal(parsed)
+			want, _ := json.Marshal(c.biblio)
+			t.Fatalf("[%s] got %s, want %s", c.about, got, want)
+		}
+	}
+}
+
+func TestCitationListTEIRoundTrip(t *testing.T) {
+	b, err := os.ReadFile("../testdata/citation_list/example.tei.xml")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for i, c := range ParseCitationList(string(b)) {
+		s, err := c.TEI()
+		if err != nil {
+			t.Fatalf("[%d] got %v, want nil", i, err)
+		}
+		parsed := ParseCitationList(s)
+		if len(parsed) != 1 {
+			t.Fatalf("[%d] got %d citations, want 1", i, len(parsed))
+		}
+		parsed[0].Index = c.Index
+		if !reflect.DeepEqual(parsed[0], c) {
+			got, _ := json.Marshal(parsed[0])
+			want, _ := json.Marshal(c)
+			t.Fatalf("[%d] got %s, want %s", i, got, want)
+		}
+	}
+}